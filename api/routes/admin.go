@@ -0,0 +1,148 @@
+package routes
+
+import (
+	"net/http"
+	"sharir/pkg/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The function handles unlock requests by lifting the lock of the account with the ID in the path.
+func UnlockUserHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := svc.UnlockUser(currentClaims(c), c.Params("id")); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"status": "success"})
+	}
+}
+
+// The RoleBody type is the request body of `/api/admin/users/:id/role`.
+// @property {string} Role - The new role: "client", "trainer" or "admin".
+type RoleBody struct {
+	Role string `json:"role"`
+}
+
+// The function handles requests to change the role of the user with the ID in the path.
+func SetRoleHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in RoleBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		if err := svc.SetRole(currentClaims(c), c.Params("id"), in.Role); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(200).JSON(fiber.Map{"status": "success"})
+	}
+}
+
+// The function reads the `page` and `limit` query parameters. Missing or invalid values fall back to
+// the defaults.
+func pageFromQuery(c *fiber.Ctx) auth.Page {
+	return auth.Page{Number: c.QueryInt("page", 1), Size: c.QueryInt("limit", auth.DefaultPageSize)}
+}
+
+// The function handles requests for a page of users. The `q` query parameter searches the name, email
+// address, phone number and username, and `role` restricts the list to one role.
+func ListUsersHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page, err := svc.ListUsers(currentClaims(c), auth.UserQuery{
+			Search: c.Query("q"),
+			Role:   c.Query("role"),
+			Page:   pageFromQuery(c),
+		})
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"data": page, "status": "success"})
+	}
+}
+
+// The function handles requests for the user with the ID in the path.
+func GetUserHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := svc.GetUser(currentClaims(c), c.Params("id"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"data": user, "status": "success"})
+	}
+}
+
+// The SuspendBody type is the request body of `/api/admin/users/:id/suspend`.
+// @property {string} Reason - Why the user is suspended. It is kept in the audit log.
+type SuspendBody struct {
+	Reason string `json:"reason"`
+}
+
+// The function handles requests to suspend the user with the ID in the path.
+func SuspendUserHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in SuspendBody
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&in); err != nil {
+				return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+			}
+		}
+		if err := svc.SuspendUser(currentClaims(c), c.Params("id"), in.Reason); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"status": "success"})
+	}
+}
+
+// The function handles requests to reactivate the suspended user with the ID in the path.
+func ReactivateUserHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := svc.ReactivateUser(currentClaims(c), c.Params("id")); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"status": "success"})
+	}
+}
+
+// The function handles requests to delete the user with the ID in the path.
+func DeleteUserHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := svc.DeleteUser(currentClaims(c), c.Params("id")); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"status": "success"})
+	}
+}
+
+// The function handles requests for a page of the audit log, newest first. The `user` query parameter
+// restricts the log to the actions taken on one user.
+func ListAuditLogHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page, err := svc.ListAuditLog(currentClaims(c), c.Query("user"), pageFromQuery(c))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"data": page, "status": "success"})
+	}
+}
+
+// The function creates the admin routes in a Fiber app. Every route requires a valid access token of
+// an admin. Reading users, suspending, reactivating and unlocking users other than admins and reading
+// the audit log can also be done with an API key that holds the matching scope; changing roles,
+// deleting users and managing API keys cannot. API keys are long-lived credentials, so only admins
+// with a verified email address can create them.
+func CreateAdminRoutes(app *fiber.App, svc auth.Service) {
+	admin := []fiber.Handler{JWTMiddleware(svc), RequireRole(auth.RoleAdmin)}
+	scoped := func(scope string) []fiber.Handler {
+		return []fiber.Handler{AuthMiddleware(svc, scope), RequireRole(auth.RoleAdmin)}
+	}
+	app.Get("/api/admin/users", append(scoped(auth.ScopeUsersRead), ListUsersHandler(svc))...)
+	app.Get("/api/admin/users/:id", append(scoped(auth.ScopeUsersRead), GetUserHandler(svc))...)
+	app.Delete("/api/admin/users/:id", append(admin, DeleteUserHandler(svc))...)
+	app.Post("/api/admin/users/:id/suspend", append(scoped(auth.ScopeUsersWrite), SuspendUserHandler(svc))...)
+	app.Post("/api/admin/users/:id/reactivate", append(scoped(auth.ScopeUsersWrite), ReactivateUserHandler(svc))...)
+	app.Post("/api/admin/users/:id/unlock", append(scoped(auth.ScopeUsersWrite), UnlockUserHandler(svc))...)
+	app.Post("/api/admin/users/:id/role", append(admin, SetRoleHandler(svc))...)
+	app.Get("/api/admin/audit", append(scoped(auth.ScopeAuditRead), ListAuditLogHandler(svc))...)
+	app.Post("/api/admin/api-keys", append(admin, RequireVerifiedEmail(svc), CreateAPIKeyHandler(svc))...)
+	app.Get("/api/admin/api-keys", append(admin, ListAPIKeysHandler(svc))...)
+	app.Delete("/api/admin/api-keys/:id", append(admin, RevokeAPIKeyHandler(svc))...)
+}
@@ -0,0 +1,56 @@
+package routes
+
+import (
+	"net/http"
+	"sharir/pkg/auth"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The APIKeyBody type is the request body of `POST /api/admin/api-keys`.
+// @property {string} Name - Who the key is for, such as the partner gym or the job.
+// @property {[]string} Scopes - The scopes of the key, such as "users:read".
+// @property ExpiresAt - When the key stops working. Keys without an expiry never expire.
+type APIKeyBody struct {
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// The function handles requests to create an API key. The key is only part of this response; it is
+// stored hashed and cannot be shown again.
+func CreateAPIKeyHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in APIKeyBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		raw, key, err := svc.CreateAPIKey(currentClaims(c), in.Name, in.Scopes, in.ExpiresAt)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(http.StatusCreated).JSON(fiber.Map{"api_key": raw, "data": key, "status": "success"})
+	}
+}
+
+// The function handles requests for a page of API keys.
+func ListAPIKeysHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page, err := svc.ListAPIKeys(currentClaims(c), pageFromQuery(c))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"data": page, "status": "success"})
+	}
+}
+
+// The function handles requests to revoke the API key with the ID in the path.
+func RevokeAPIKeyHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := svc.RevokeAPIKey(currentClaims(c), c.Params("id")); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"status": "success"})
+	}
+}
@@ -5,27 +5,27 @@ package routes
 // and the `fiber` package from the `github.com/gofiber/fiber/v2` repository. These packages are used
 // in the code to handle HTTP requests and responses, and to interact with the authentication service.
 import (
+	"errors"
 	"net/http"
-	"os"
+	"sharir/pkg"
 	"sharir/pkg/auth"
 
 	"github.com/gofiber/fiber/v2"
-	jwtware "github.com/gofiber/jwt/v3"
 )
 
 // The function handles sign up requests by parsing the request body, calling the sign up service, and
-// returning a JSON response with a refresh token.
+// returning a JSON response with an access token and a refresh token.
 func SignUpHandler(repo *auth.Repo, svc auth.Service) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var in auth.InUser
 		if err := c.BodyParser(&in); err != nil {
 			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
 		}
-		refreshToken, err := svc.SignUp(in)
+		tokens, err := svc.SignUp(in, deviceFromRequest(c))
 		if err != nil {
-			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
 		}
-		return c.Status(200).JSON(fiber.Map{"token": refreshToken, "status": "success"})
+		return c.Status(200).JSON(tokenResponse(tokens))
 	}
 }
 func LoginHandler(repo *auth.Repo, svc auth.Service) fiber.Handler {
@@ -34,20 +34,92 @@ func LoginHandler(repo *auth.Repo, svc auth.Service) fiber.Handler {
 		if err := c.BodyParser(&in); err != nil {
 			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
 		}
-		refreshToken, err := svc.Login(in.PhoneNumber, in.Password)
+		tokens, err := svc.Login(in.LoginIdentifier(), in.Password, deviceFromRequest(c))
+		var mfa *pkg.MFARequiredError
+		if errors.As(err, &mfa) {
+			return c.Status(200).JSON(fiber.Map{"mfa_token": mfa.Token, "status": "mfa_required"})
+		}
+		var locked *pkg.AccountLockedError
+		if errors.As(err, &locked) {
+			return c.Status(http.StatusLocked).JSON(fiber.Map{"error": err.Error(), "locked_until": locked.Until, "status": "locked"})
+		}
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(200).JSON(tokenResponse(tokens))
+	}
+}
+
+// The function handles refresh requests by exchanging the refresh token in the request body for a new
+// access token and a new refresh token.
+func RefreshHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in auth.RefreshBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		tokens, err := svc.Refresh(in.RefreshToken, deviceFromRequest(c))
 		if err != nil {
-			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
 		}
-		return c.Status(200).JSON(fiber.Map{"token": refreshToken, "status": "success"})
+		return c.Status(200).JSON(tokenResponse(tokens))
+	}
+}
+
+// The function handles logout requests by revoking the access token the request was made with and the
+// refresh tokens of the same session.
+func LogoutHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := svc.Logout(currentClaims(c)); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"status": "success"})
+	}
+}
+
+// The function handles logout-all requests by revoking every access token and refresh token of the
+// user the request was made by.
+func LogoutAllHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := svc.LogoutAll(currentClaims(c)); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"status": "success"})
+	}
+}
+
+// The function handles requests to describe the caller. Partners and batch jobs can use it to check
+// their API key and its scopes; users get the claims of their access token.
+func WhoAmIHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := currentClaims(c)
+		if claims.APIKeyID != "" {
+			return c.Status(200).JSON(fiber.Map{"api_key_id": claims.APIKeyID, "scopes": claims.Scopes, "status": "success"})
+		}
+		return c.Status(200).JSON(fiber.Map{"user_id": claims.UserID, "email": claims.Email, "role": claims.Role, "status": "success"})
+	}
+}
+
+// The function builds the JSON body that is returned whenever a new token pair is issued. The access
+// token is kept under the `token` key so existing clients keep working.
+func tokenResponse(tokens auth.TokenPair) fiber.Map {
+	return fiber.Map{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"status":        "success",
 	}
 }
 
 // The function handles sign up requests by parsing the request body, calling the sign up service, and
 // returning a JSON response with a refresh token.
-func CreateAuthRoutes(app *fiber.App, userRepo *auth.Repo, svc auth.Service) {
+func CreateAuthRoutes(app *fiber.App, userRepo *auth.Repo, svc auth.Service, limits RateLimits) {
 	app.Post("/api/auth/register", SignUpHandler(userRepo, svc))
-	app.Post("/api/auth/login", LoginHandler(userRepo, svc))
-	app.Use(jwtware.New(jwtware.Config{
-		SigningKey: []byte(os.Getenv("JWT_SECRET")),
-	}))
+	app.Post("/api/auth/login", append(limits.Login, LoginHandler(userRepo, svc))...)
+	app.Post("/api/auth/refresh", RefreshHandler(svc))
+
+	protected := JWTMiddleware(svc)
+	app.Post("/api/auth/logout", protected, LogoutHandler(svc))
+	app.Post("/api/auth/logout-all", protected, LogoutAllHandler(svc))
+	app.Get("/api/auth/whoami", AuthMiddleware(svc), WhoAmIHandler())
 }
@@ -0,0 +1,103 @@
+package routes
+
+import (
+	"html/template"
+	"net/http"
+	"sharir/pkg"
+	"sharir/pkg/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The function returns a middleware that only lets users with a verified email address through. It is
+// meant for sensitive actions and must run after `JWTMiddleware`.
+func RequireVerifiedEmail(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		verified, err := svc.IsEmailVerified(currentClaims(c).UserID)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		if !verified {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": pkg.ErrEmailNotVerified.Error(), "status": "failed"})
+		}
+		return c.Next()
+	}
+}
+
+// The function handles requests for a new verification email for the logged in user.
+func SendEmailVerificationHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := svc.SendEmailVerification(currentClaims(c).UserID); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(http.StatusAccepted).JSON(fiber.Map{"message": "verification email sent", "status": "success"})
+	}
+}
+
+// `confirmEmailPage` is the page verification links open. Opening the link does not verify the
+// address, so that mail scanners and link previews fetching it cannot; the user confirms with the
+// button, which posts the token to the verify route.
+var confirmEmailPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Verify your email address</title></head>
+<body>
+<form method="post" action="/api/auth/email/verify">
+<input type="hidden" name="token" value="{{.}}">
+<button type="submit">Verify my email address</button>
+</form>
+</body>
+</html>
+`))
+
+// `emailVerifiedPage` is shown to browsers once the confirmation form has been posted.
+var emailVerifiedPage = template.Must(template.New("verified").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Verify your email address</title></head>
+<body><p>{{.}}</p></body>
+</html>
+`))
+
+// The function handles verification links by serving the page that asks the user to confirm the
+// address. It does not change anything.
+func ConfirmEmailPageHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set("Referrer-Policy", "no-referrer")
+		c.Type("html", "utf-8")
+		return confirmEmailPage.Execute(c, c.Query("token"))
+	}
+}
+
+// The function handles email confirmations by marking the email address the link was sent to as
+// verified. The token is read from the request body. Browsers posting the confirmation page get a
+// page back, other clients get JSON.
+func VerifyEmailHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in auth.VerifyEmailBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		err := svc.VerifyEmail(in.Token)
+		if c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMETextHTML {
+			message := "Your email address is verified."
+			if err != nil {
+				c.Status(errorStatus(err))
+				message = "This link is invalid or has expired. Please ask for a new verification email."
+			}
+			c.Type("html", "utf-8")
+			return emailVerifiedPage.Execute(c, message)
+		}
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"message": "email verified", "status": "success"})
+	}
+}
+
+// The function creates the email verification routes in a Fiber app. Requesting a verification email
+// requires a valid access token; the link itself does not. The link opens a confirmation page and
+// only posting it verifies the address.
+func CreateEmailRoutes(app *fiber.App, svc auth.Service) {
+	app.Post("/api/auth/email/verification", JWTMiddleware(svc), SendEmailVerificationHandler(svc))
+	app.Get("/api/auth/email/verify", ConfirmEmailPageHandler())
+	app.Post("/api/auth/email/verify", VerifyEmailHandler(svc))
+}
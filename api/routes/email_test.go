@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sharir/pkg"
+	"sharir/pkg/auth"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// verifyEmailSvc records the tokens `VerifyEmail` is called with. Other methods of the service panic
+// through the embedded nil interface.
+type verifyEmailSvc struct {
+	auth.Service
+	tokens []string
+}
+
+func (s *verifyEmailSvc) VerifyEmail(token string) error {
+	s.tokens = append(s.tokens, token)
+	if token != "good" {
+		return pkg.ErrInvalidGrant
+	}
+	return nil
+}
+
+func TestVerifyEmailLinkDoesNotVerify(t *testing.T) {
+	svc := &verifyEmailSvc{}
+	app := fiber.New()
+	CreateEmailRoutes(app, svc)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/auth/email/verify?token=good", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), `name="token" value="good"`) {
+		t.Fatalf("GET returned %d with %q, want the confirmation form", resp.StatusCode, page)
+	}
+	if len(svc.tokens) != 0 {
+		t.Fatalf("GET verified the address with %v", svc.tokens)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/email/verify", strings.NewReader(url.Values{"token": {"good"}}.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	req.Header.Set(fiber.HeaderAccept, "text/html,application/xhtml+xml,*/*;q=0.8")
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(svc.tokens) != 1 || svc.tokens[0] != "good" {
+		t.Fatalf("posting the form returned %d and verified %v", resp.StatusCode, svc.tokens)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/auth/email/verify", strings.NewReader(`{"token":"bad"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("posting a bad token returned %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
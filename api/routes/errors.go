@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"sharir/pkg"
+)
+
+// The function maps errors returned by the services to the HTTP status code that should be sent to
+// the client. Only the errors in `pkg` describe a problem with the request; anything else, such as a
+// failing database, is a fault of the server and reported as an internal server error.
+func errorStatus(err error) int {
+	var locked *pkg.AccountLockedError
+	switch {
+	case errors.As(err, &locked):
+		return http.StatusLocked
+	case errors.Is(err, pkg.ErrInvalidRefreshToken), errors.Is(err, pkg.ErrRefreshTokenReused),
+		errors.Is(err, pkg.ErrInvalidGrant), errors.Is(err, pkg.ErrIncorrectPassword),
+		errors.Is(err, pkg.ErrInvalidMFACode), errors.Is(err, pkg.ErrInvalidPasskey),
+		errors.Is(err, pkg.ErrSocialLoginFailed), errors.Is(err, pkg.ErrInvalidAPIKey),
+		errors.Is(err, pkg.ErrInvalidCredentials), errors.Is(err, pkg.ErrTokenRevoked):
+		return http.StatusUnauthorized
+	case errors.Is(err, pkg.ErrOTPPending):
+		return http.StatusUnauthorized
+	case errors.Is(err, pkg.ErrOTPExpired):
+		return http.StatusGone
+	case errors.Is(err, pkg.ErrOTPCooldown), errors.Is(err, pkg.ErrOTPMaxAttempts):
+		return http.StatusTooManyRequests
+	case errors.Is(err, pkg.ErrEmailAlreadyVerified), errors.Is(err, pkg.ErrTOTPAlreadyEnabled),
+		errors.Is(err, pkg.ErrPasskeyExists), errors.Is(err, pkg.ErrSocialAccountConflict),
+		errors.Is(err, pkg.ErrPhoneNumberTaken), errors.Is(err, pkg.ErrEmailTaken),
+		errors.Is(err, pkg.ErrUsernameTaken):
+		return http.StatusConflict
+	case errors.Is(err, pkg.ErrRoleNotAllowed), errors.Is(err, pkg.ErrAccountSuspended),
+		errors.Is(err, pkg.ErrEmailNotVerified):
+		return http.StatusForbidden
+	case errors.Is(err, pkg.ErrWeakPassword):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, pkg.ErrUserNotFound), errors.Is(err, pkg.ErrPasskeyNotFound),
+		errors.Is(err, pkg.ErrUnknownProvider), errors.Is(err, pkg.ErrSessionNotFound),
+		errors.Is(err, pkg.ErrAPIKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, pkg.ErrEmailRequired), errors.Is(err, pkg.ErrTOTPNotEnrolled),
+		errors.Is(err, pkg.ErrInvalidRole), errors.Is(err, pkg.ErrInvalidScope),
+		errors.Is(err, pkg.ErrInvalidAPIKeyRequest), errors.Is(err, pkg.ErrInvalidPhoneNumber):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,41 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sharir/pkg"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestErrorStatus(t *testing.T) {
+	clientErrors := []error{
+		pkg.ErrUserNotFound, pkg.ErrInvalidRefreshToken, pkg.ErrRefreshTokenReused, pkg.ErrTokenRevoked,
+		pkg.ErrOTPPending, pkg.ErrOTPExpired, pkg.ErrOTPMaxAttempts, pkg.ErrOTPCooldown, pkg.ErrInvalidGrant,
+		pkg.ErrWeakPassword, pkg.ErrIncorrectPassword, pkg.ErrEmailRequired, pkg.ErrEmailAlreadyVerified,
+		pkg.ErrEmailNotVerified, pkg.ErrInvalidMFACode, pkg.ErrTOTPNotEnrolled, pkg.ErrTOTPAlreadyEnabled,
+		pkg.ErrInvalidPasskey, pkg.ErrPasskeyExists, pkg.ErrPasskeyNotFound, pkg.ErrUnknownProvider,
+		pkg.ErrSocialLoginFailed, pkg.ErrSessionNotFound, pkg.ErrInvalidRole, pkg.ErrRoleNotAllowed,
+		pkg.ErrAccountSuspended, pkg.ErrInvalidAPIKey, pkg.ErrInvalidScope, pkg.ErrInvalidAPIKeyRequest,
+		pkg.ErrAPIKeyNotFound, pkg.ErrPhoneNumberTaken, pkg.ErrEmailTaken, pkg.ErrUsernameTaken,
+		pkg.ErrInvalidPhoneNumber, pkg.ErrInvalidCredentials, pkg.ErrSocialAccountConflict,
+		&pkg.AccountLockedError{Until: time.Now()},
+	}
+	for _, err := range clientErrors {
+		status := errorStatus(err)
+		if status < 400 || status >= 500 {
+			t.Errorf("%v: status %d, want a client error", err, status)
+		}
+		if wrapped := errorStatus(fmt.Errorf("%w: detail", err)); wrapped != status {
+			t.Errorf("%v wrapped: status %d, want %d", err, wrapped, status)
+		}
+	}
+	for _, err := range []error{errors.New("connection refused"), mongo.ErrClientDisconnected, mongo.ErrNoDocuments} {
+		if status := errorStatus(err); status != http.StatusInternalServerError {
+			t.Errorf("%v: status %d, want %d", err, status, http.StatusInternalServerError)
+		}
+	}
+}
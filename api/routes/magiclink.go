@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"sharir/pkg"
+	"sharir/pkg/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The function handles requests for a magic login link by emailing one to the address in the request
+// body. The response is the same whether or not an account has the address.
+func SendMagicLinkHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in auth.MagicLinkBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		if err := svc.SendMagicLink(in.Email); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(http.StatusAccepted).JSON(fiber.Map{"message": "if an account exists for this address, a login link has been sent", "status": "success"})
+	}
+}
+
+// The function handles magic link logins by exchanging the token of the link for an access token and
+// a refresh token. Users with TOTP enabled get a challenge token to finish with `/api/auth/2fa/verify`,
+// just like after a password login.
+func RedeemMagicLinkHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in auth.RedeemMagicLinkBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		tokens, err := svc.RedeemMagicLink(in.Token, deviceFromRequest(c))
+		var mfa *pkg.MFARequiredError
+		if errors.As(err, &mfa) {
+			return c.Status(200).JSON(fiber.Map{"mfa_token": mfa.Token, "status": "mfa_required"})
+		}
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(200).JSON(tokenResponse(tokens))
+	}
+}
+
+// The function creates the magic link routes in a Fiber app. Requesting a link has its own rate
+// limits; redeeming one is a login and shares the login rate limits. The link itself opens a page of
+// the client app, which posts the token to the redeem route, so that mail scanners following the link
+// cannot use it up.
+func CreateMagicLinkRoutes(app *fiber.App, svc auth.Service, limits RateLimits) {
+	app.Post("/api/auth/magic-link", append(limits.MagicLink, SendMagicLinkHandler(svc))...)
+	app.Post("/api/auth/magic-link/redeem", append(limits.Login, RedeemMagicLinkHandler(svc))...)
+}
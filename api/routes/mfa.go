@@ -0,0 +1,76 @@
+package routes
+
+import (
+	"net/http"
+	"sharir/pkg/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The function handles TOTP enrollment requests by creating a new secret for the logged in user. The
+// `otpauth_uri` can be rendered as a QR code for authenticator apps.
+func EnrollTOTPHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		secret, uri, err := svc.EnrollTOTP(currentClaims(c).UserID)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"secret": secret, "otpauth_uri": uri, "status": "success"})
+	}
+}
+
+// The function handles TOTP confirmation requests. It enables TOTP for the logged in user and returns
+// the recovery codes, which are not shown again.
+func ConfirmTOTPHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in auth.TOTPCodeBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		codes, err := svc.ConfirmTOTP(currentClaims(c).UserID, in.Code)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(200).JSON(fiber.Map{"recovery_codes": codes, "status": "success"})
+	}
+}
+
+// The function handles requests to turn TOTP off for the logged in user.
+func DisableTOTPHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in auth.TOTPCodeBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		if err := svc.DisableTOTP(currentClaims(c).UserID, in.Code); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(200).JSON(fiber.Map{"status": "success"})
+	}
+}
+
+// The function handles the second step of a login for users with TOTP enabled. It exchanges the
+// challenge token returned by the login and a code for an access token and a refresh token.
+func VerifyMFAHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in auth.MFABody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		tokens, err := svc.VerifyMFA(in.MFAToken, in.Code, deviceFromRequest(c))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(200).JSON(tokenResponse(tokens))
+	}
+}
+
+// The function creates the two-factor authentication routes in a Fiber app. Managing TOTP requires a
+// valid access token. The verify step is part of the login and shares its rate limits.
+func CreateMFARoutes(app *fiber.App, svc auth.Service, limits RateLimits) {
+	protected := JWTMiddleware(svc)
+	app.Post("/api/auth/2fa/totp/enroll", protected, EnrollTOTPHandler(svc))
+	app.Post("/api/auth/2fa/totp/confirm", protected, ConfirmTOTPHandler(svc))
+	app.Post("/api/auth/2fa/totp/disable", protected, DisableTOTPHandler(svc))
+	app.Post("/api/auth/2fa/verify", append(limits.Login, VerifyMFAHandler(svc))...)
+}
@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"net/http"
+	"os"
+	"sharir/pkg"
+	"sharir/pkg/auth"
+
+	"github.com/gofiber/fiber/v2"
+	jwtware "github.com/gofiber/jwt/v3"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// The function returns the middleware that protects authenticated routes. On top of the signature and
+// `exp` checks done by `jwtware`, every request is checked against the revocation store so that tokens
+// revoked through logout stop working immediately. Grants are signed with the same key but are not
+// access tokens, so they are rejected.
+func JWTMiddleware(svc auth.Service) fiber.Handler {
+	return jwtware.New(jwtware.Config{
+		SigningKey: []byte(os.Getenv("JWT_SECRET")),
+		SuccessHandler: func(c *fiber.Ctx) error {
+			claims := currentClaims(c)
+			if claims.Purpose != "" {
+				return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": pkg.ErrInvalidGrant.Error(), "status": "failed"})
+			}
+			revoked, err := svc.IsRevoked(claims)
+			if err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+			}
+			if revoked {
+				return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": pkg.ErrTokenRevoked.Error(), "status": "failed"})
+			}
+			return c.Next()
+		},
+	})
+}
+
+// The header API keys are sent in.
+const apiKeyHeader = "X-API-Key"
+
+// The function returns the middleware for routes that partners and batch jobs can call as well as
+// users. A request with an `X-API-Key` header is authenticated with the key, which must hold every one
+// of `scopes`; any other request must carry a user access token and is handled by `JWTMiddleware`.
+// Routes that are not meant for API keys should use `JWTMiddleware` directly.
+func AuthMiddleware(svc auth.Service, scopes ...string) fiber.Handler {
+	jwtMiddleware := JWTMiddleware(svc)
+	return func(c *fiber.Ctx) error {
+		raw := c.Get(apiKeyHeader)
+		if raw == "" {
+			return jwtMiddleware(c)
+		}
+		claims, err := svc.AuthenticateAPIKey(raw)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "insufficient scope", "status": "failed"})
+			}
+		}
+		c.Locals("apikey", claims)
+		return c.Next()
+	}
+}
+
+// The function returns the claims of the access token that `JWTMiddleware` verified for the current
+// request, or of the API key `AuthMiddleware` verified.
+func currentClaims(c *fiber.Ctx) auth.AccessClaims {
+	if claims, ok := c.Locals("apikey").(auth.AccessClaims); ok {
+		return claims
+	}
+	token, ok := c.Locals("user").(*jwt.Token)
+	if !ok {
+		return auth.AccessClaims{}
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	return auth.ClaimsFromMap(claims)
+}
+
+// The function returns a middleware that only lets users with one of the given roles through, for
+// example `RequireRole(auth.RoleTrainer)`. Admins are let through everywhere. The role is taken from
+// the access token, so it must run after `JWTMiddleware`. Requests made with an API key have no role;
+// they are let through because `AuthMiddleware` has already checked the key's scopes.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := currentClaims(c)
+		if claims.Role == auth.RoleAdmin || claims.APIKeyID != "" {
+			return c.Next()
+		}
+		role := claims.Role
+		for _, r := range roles {
+			if role == r {
+				return c.Next()
+			}
+		}
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "insufficient role", "status": "failed"})
+	}
+}
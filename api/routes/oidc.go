@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"sharir/pkg"
+	"sharir/pkg/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The function handles requests to start a social login. It redirects to the identity provider, or
+// returns the URL as JSON when the client asks for JSON, which suits mobile apps that open the page
+// themselves.
+func BeginSocialLoginHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		url, err := svc.BeginSocialLogin(c.UserContext(), c.Params("provider"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		if c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON {
+			return c.Status(200).JSON(fiber.Map{"authorization_url": url, "status": "success"})
+		}
+		return c.Redirect(url, http.StatusFound)
+	}
+}
+
+// The function handles the callback of the identity provider. The code and state arrive in the query
+// string, or as a form post for providers that use the "form_post" response mode.
+func SocialLoginCallbackHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		param := func(key string) string {
+			if v := c.Query(key); v != "" {
+				return v
+			}
+			return c.FormValue(key)
+		}
+		if e := param("error"); e != "" {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": pkg.ErrSocialLoginFailed.Error() + ": " + e, "status": "failed"})
+		}
+		tokens, isNew, err := svc.FinishSocialLogin(c.UserContext(), c.Params("provider"), param("state"), param("code"), deviceFromRequest(c))
+		var mfa *pkg.MFARequiredError
+		if errors.As(err, &mfa) {
+			return c.Status(200).JSON(fiber.Map{"mfa_token": mfa.Token, "status": "mfa_required"})
+		}
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		res := tokenResponse(tokens)
+		res["is_new_user"] = isNew
+		return c.Status(200).JSON(res)
+	}
+}
+
+// The function creates the social login routes in a Fiber app.
+func CreateOIDCRoutes(app *fiber.App, svc auth.Service) {
+	app.Get("/api/auth/oidc/:provider/start", BeginSocialLoginHandler(svc))
+	app.Get("/api/auth/oidc/:provider/callback", SocialLoginCallbackHandler(svc))
+	app.Post("/api/auth/oidc/:provider/callback", SocialLoginCallbackHandler(svc))
+}
@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"net/http"
+	"sharir/pkg/auth"
+	"sharir/pkg/webauthn"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The PasskeyRegistrationBody type is the request body that finishes registering a passkey.
+// @property {string} Session - The session token returned when the registration was started.
+// @property {string} Name - A name for the passkey, such as "iPhone".
+// @property Credential - The result of `navigator.credentials.create`.
+type PasskeyRegistrationBody struct {
+	Session    string                       `json:"session"`
+	Name       string                       `json:"name"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+}
+
+// The PasskeyLoginBody type is the request body that finishes a passkey login.
+// @property {string} Session - The session token returned when the login was started.
+// @property Credential - The result of `navigator.credentials.get`.
+type PasskeyLoginBody struct {
+	Session    string                     `json:"session"`
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
+
+// The function handles requests to start registering a passkey for the logged in user.
+func BeginPasskeyRegistrationHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		options, session, err := svc.BeginPasskeyRegistration(currentClaims(c).UserID)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"options": options, "session": session, "status": "success"})
+	}
+}
+
+// The function handles requests that finish registering a passkey and returns the stored passkey.
+func FinishPasskeyRegistrationHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in PasskeyRegistrationBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		passkey, err := svc.FinishPasskeyRegistration(currentClaims(c).UserID, in.Session, in.Name, in.Credential)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(http.StatusCreated).JSON(fiber.Map{"passkey": passkey, "status": "success"})
+	}
+}
+
+// The function handles requests to start a passkey login.
+func BeginPasskeyLoginHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		options, session, err := svc.BeginPasskeyLogin()
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"options": options, "session": session, "status": "success"})
+	}
+}
+
+// The function handles requests that finish a passkey login by returning an access token and a
+// refresh token.
+func FinishPasskeyLoginHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in PasskeyLoginBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		tokens, err := svc.FinishPasskeyLogin(in.Session, in.Credential, deviceFromRequest(c))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(200).JSON(tokenResponse(tokens))
+	}
+}
+
+// The function handles requests for the passkeys of the logged in user.
+func ListPasskeysHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		passkeys, err := svc.ListPasskeys(currentClaims(c).UserID)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"passkeys": passkeys, "status": "success"})
+	}
+}
+
+// The function handles requests to remove one of the passkeys of the logged in user.
+func DeletePasskeyHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := svc.DeletePasskey(currentClaims(c).UserID, c.Params("id")); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"status": "success"})
+	}
+}
+
+// The function creates the passkey routes in a Fiber app. Managing passkeys requires a valid access
+// token; logging in with one shares the login rate limits.
+func CreatePasskeyRoutes(app *fiber.App, svc auth.Service, limits RateLimits) {
+	protected := JWTMiddleware(svc)
+	app.Post("/api/auth/passkeys/register/begin", protected, BeginPasskeyRegistrationHandler(svc))
+	app.Post("/api/auth/passkeys/register/finish", protected, FinishPasskeyRegistrationHandler(svc))
+	app.Get("/api/auth/passkeys", protected, ListPasskeysHandler(svc))
+	app.Delete("/api/auth/passkeys/:id", protected, DeletePasskeyHandler(svc))
+	app.Post("/api/auth/passkeys/login/begin", append(limits.Login, BeginPasskeyLoginHandler(svc))...)
+	app.Post("/api/auth/passkeys/login/finish", append(limits.Login, FinishPasskeyLoginHandler(svc))...)
+}
@@ -0,0 +1,81 @@
+package routes
+
+import (
+	"net/http"
+	"sharir/pkg/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The function handles forgot password requests by sending a reset OTP to the phone number in the
+// request body. The response is the same whether or not an account has the phone number.
+func ForgotPasswordHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in auth.ForgotPasswordBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		if err := svc.ForgotPassword(in.PhoneNumber); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(http.StatusAccepted).JSON(fiber.Map{"message": "if an account exists for this number, a code has been sent", "status": "success"})
+	}
+}
+
+// The function handles reset verification requests by checking the OTP in the request body and
+// returning a single-use reset token.
+func VerifyPasswordResetHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in auth.VerifyResetBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		resetToken, err := svc.VerifyPasswordReset(in.PhoneNumber, in.Code)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(200).JSON(fiber.Map{"reset_token": resetToken, "status": "success"})
+	}
+}
+
+// The function handles reset requests by setting the new password in the request body using the
+// reset token. All sessions of the user are signed out.
+func ResetPasswordHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in auth.ResetPasswordBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		if err := svc.ResetPassword(in.ResetToken, in.Password); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(200).JSON(fiber.Map{"status": "success"})
+	}
+}
+
+// The function handles change password requests of a logged in user. On success every other session
+// of the user is signed out and a new token pair for the current session is returned.
+func ChangePasswordHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in auth.ChangePasswordBody
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "status": "failed1"})
+		}
+		tokens, err := svc.ChangePassword(currentClaims(c), in.CurrentPassword, in.NewPassword)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed2"})
+		}
+		return c.Status(200).JSON(tokenResponse(tokens))
+	}
+}
+
+// The function creates the password routes in a Fiber app. Sending the reset OTP shares the send OTP
+// rate limits and checking it shares the verify OTP rate limits. Changing the password requires a
+// valid access token and the current password. It does not require a verified email address, since
+// accounts created with a phone number may not have one.
+func CreatePasswordRoutes(app *fiber.App, svc auth.Service, limits RateLimits) {
+	app.Post("/api/auth/password/forgot", append(limits.SendOTP, ForgotPasswordHandler(svc))...)
+	app.Post("/api/auth/password/verify", append(limits.VerifyOTP, VerifyPasswordResetHandler(svc))...)
+	app.Post("/api/auth/password/reset", ResetPasswordHandler(svc))
+	app.Post("/api/auth/password/change", JWTMiddleware(svc), ChangePasswordHandler(svc))
+}
@@ -4,17 +4,15 @@ package routes
 // phone OTP routes in a Fiber app. These packages include:
 import (
 	"context"
-	"log"
+	"errors"
 	"net/http"
-	"os"
+	"sharir/pkg"
 	"sharir/pkg/auth"
+	"sharir/pkg/otp"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	"github.com/joho/godotenv"
-	"github.com/twilio/twilio-go"
-	twilioApi "github.com/twilio/twilio-go/rest/verify/v2"
 )
 
 // `const appTimeout = time.Second * 10` is defining a constant variable `appTimeout` with a value of
@@ -67,6 +65,10 @@ type jsonResponse struct {
 // data in the `validateBody` function.
 var validate = validator.New()
 
+// `errMissingPhoneNumber` is returned when a verify request does not say which phone number the code
+// belongs to.
+var errMissingPhoneNumber = errors.New("phone number is required")
+
 // The function validates the request body using a given struct and returns an error if validation
 // fails.
 func validateBody(c *fiber.Ctx, data interface{}) error {
@@ -93,82 +95,10 @@ func errorJSON(c *fiber.Ctx, err error, status ...int) {
 	c.Status(statusCode).JSON(jsonResponse{Status: statusCode, Message: err.Error()})
 }
 
-// The function loads the .env file and returns the value of the TWILIO_ACCOUNT_SID environment
-// variable.
-func envACCOUNTSID() string {
-	println(godotenv.Unmarshal(".env"))
-	err := godotenv.Load(".env")
-	if err != nil {
-		log.Fatalln(err)
-		log.Fatal("Error loading .env file")
-	}
-	return os.Getenv("TWILIO_ACCOUNT_SID")
-}
-
-// This function loads the .env file and returns the value of the TWILIO_AUTHTOKEN environment
-// variable.
-func envAUTHTOKEN() string {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-	return os.Getenv("TWILIO_AUTHTOKEN")
-}
-
-// This function loads the environment variables from a .env file and returns the value of the
-// TWILIO_SERVICES_ID variable.
-func envSERVICESID() string {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-	return os.Getenv("TWILIO_SERVICES_ID")
-}
-
-// This line of code is creating a new instance of the `twilio.RestClient` struct and assigning it to
-// the `client` variable. The `twilio.NewRestClientWithParams()` function is used to create the new
-// instance, and it takes a `twilio.ClientParams` struct as an argument. The `twilio.ClientParams`
-// struct contains the `Username` and `Password` fields, which are set to the values of the
-// `envACCOUNTSID()` and `envAUTHTOKEN()` functions, respectively. These functions load the values of
-// the `TWILIO_ACCOUNT_SID` and `TWILIO_AUTHTOKEN` environment variables from a `.env` file and return
-// them. The `client` variable is then used to make requests to Twilio's API.
-var client *twilio.RestClient = twilio.NewRestClientWithParams(twilio.ClientParams{
-	Username: envACCOUNTSID(),
-	Password: envAUTHTOKEN(),
-})
-
-// The function sends an OTP (one-time password) to a phone number using Twilio's API.
-func twilioSendOTP(phoneNumber string) (string, error) {
-	params := &twilioApi.CreateVerificationParams{}
-	params.SetTo(phoneNumber)
-	params.SetChannel("sms")
-
-	resp, err := client.VerifyV2.CreateVerification(envSERVICESID(), params)
-	if err != nil {
-		return "", err
-	}
-
-	return *resp.Sid, nil
-}
-
-// The function verifies an OTP code sent to a phone number using Twilio API.
-func twilioVerifyOTP(phoneNumber string, code string) error {
-	params := &twilioApi.CreateVerificationCheckParams{}
-	params.SetTo(phoneNumber)
-	params.SetCode(code)
-
-	resp, err := client.VerifyV2.CreateVerificationCheck(envSERVICESID(), params)
-	if err != nil {
-		return err
-	} else if *resp.Status == "approved" {
-		return nil
-	}
-
-	return nil
-}
-
-// The function sends an OTP SMS message using Twilio API and returns a success message.
-func sendSMS() fiber.Handler {
+// The function sends an OTP SMS message through the OTP provider and returns a success message.
+// Errors such as the resend cooldown are written with their own status code and not handed back to
+// Fiber, whose error handler would replace the JSON body.
+func sendSMS(provider otp.OTPProvider) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		_, cancel := context.WithTimeout(context.Background(), appTimeout)
 		defer cancel()
@@ -179,17 +109,20 @@ func sendSMS() fiber.Handler {
 		newData := OTPData{
 			PhoneNumber: payload.PhoneNumber,
 		}
-		_, err := twilioSendOTP(newData.PhoneNumber)
+		err := provider.SendOTP(newData.PhoneNumber)
 		if err != nil {
-			errorJSON(c, err)
-			return err
+			errorJSON(c, err, errorStatus(err))
+			return nil
 		}
 		writeJSON(c, http.StatusAccepted, "OTP sent successfully")
 		return nil
 	}
 }
 
-// The function verifies an SMS OTP code using Twilio API and returns a success message.
+// The function verifies an SMS OTP code and logs the user in. The code is checked by the service
+// before any token is issued, so only an approved code returns a token. Phone numbers without an
+// account are signed up on the spot and `is_new_user` tells the client to complete the profile. Users
+// with TOTP enabled get a challenge token to finish with `/api/auth/2fa/verify`.
 func verifySMS(svc auth.Service) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		_, cancel := context.WithTimeout(c.Context(), appTimeout)
@@ -199,32 +132,40 @@ func verifySMS(svc auth.Service) fiber.Handler {
 		if err := c.BodyParser(&payload); err != nil {
 			return err
 		}
+		if payload.User == nil {
+			errorJSON(c, errMissingPhoneNumber)
+			return nil
+		}
 		newData := VerifyData{
 			User: payload.User,
 			Code: payload.Code,
 		}
-		token, err := svc.LoginPhoneOtp(newData.User.PhoneNumber)
-		if err != nil {
-			errorJSON(c, err)
-			return err
+		tokens, isNewUser, err := svc.LoginPhoneOtp(newData.User.PhoneNumber, newData.Code, deviceFromRequest(c))
+		var mfa *pkg.MFARequiredError
+		if errors.As(err, &mfa) {
+			return c.Status(200).JSON(fiber.Map{"mfa_token": mfa.Token, "status": "mfa_required"})
 		}
-
-		err = twilioVerifyOTP(newData.User.PhoneNumber, newData.Code)
 		if err != nil {
-			errorJSON(c, err)
-			return err
+			errorJSON(c, err, errorStatus(err))
+			return nil
 		}
 		return c.JSON(fiber.Map{
-			"status":  http.StatusOK,
-			"message": "OTP verified successfully",
-			"token":   token,
+			"status":        http.StatusOK,
+			"message":       "OTP verified successfully",
+			"token":         tokens.AccessToken,
+			"refresh_token": tokens.RefreshToken,
+			"expires_in":    tokens.ExpiresIn,
+			"is_new_user":   isNewUser,
 		})
 
 	}
 }
 
-// The function creates two routes for sending and verifying phone OTPs in a Fiber app.
-func CreatePhoneOtpRoutes(app *fiber.App, svc auth.Service) {
-	app.Post("/api/auth/sendotp", sendSMS())
-	app.Post("/api/auth/verifyotp", verifySMS(svc))
+// The function creates two routes for sending and verifying phone OTPs in a Fiber app. Codes are sent
+// through the given OTP provider and checked by the service. Both routes are throttled by the given
+// rate limits. The provider has to accept phone numbers as clients send them, see
+// `otp.WithPhoneNormalization`.
+func CreatePhoneOtpRoutes(app *fiber.App, svc auth.Service, provider otp.OTPProvider, limits RateLimits) {
+	app.Post("/api/auth/sendotp", append(limits.SendOTP, sendSMS(provider))...)
+	app.Post("/api/auth/verifyotp", append(limits.VerifyOTP, verifySMS(svc))...)
 }
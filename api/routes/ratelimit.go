@@ -0,0 +1,161 @@
+package routes
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sharir/pkg/auth"
+	"sharir/pkg/configuration"
+	"sharir/pkg/phone"
+	"sharir/pkg/ratelimit"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The RateLimits type holds the rate limit middleware of every throttled endpoint. Each field is the
+// chain of limiters that runs before the endpoint's handler.
+type RateLimits struct {
+	SendOTP   []fiber.Handler
+	VerifyOTP []fiber.Handler
+	Login     []fiber.Handler
+	MagicLink []fiber.Handler
+}
+
+// The function builds the rate limit middleware of the throttled endpoints from the configured limits.
+// Counters are kept in the given store. Phone numbers are counted in their E.164 form, read in
+// `region` when they have no country code, so that writing a number differently does not get around
+// the per phone number limits.
+func NewRateLimits(store ratelimit.Store, limits configuration.RateLimits, region string) (RateLimits, error) {
+	sendOTP, err := limitChain(store, "sendotp", limits.SendOTP, normalizedPhone(phoneFromOTPData, region))
+	if err != nil {
+		return RateLimits{}, err
+	}
+	verifyOTP, err := limitChain(store, "verifyotp", limits.VerifyOTP, normalizedPhone(phoneFromVerifyData, region))
+	if err != nil {
+		return RateLimits{}, err
+	}
+	login, err := limitChain(store, "login", limits.Login, identifierFromAuthBody(region))
+	if err != nil {
+		return RateLimits{}, err
+	}
+	magicLink, err := limitChain(store, "magiclink", limits.MagicLink, emailFromMagicLinkBody)
+	if err != nil {
+		return RateLimits{}, err
+	}
+	return RateLimits{SendOTP: sendOTP, VerifyOTP: verifyOTP, Login: login, MagicLink: magicLink}, nil
+}
+
+// The function returns the per IP, per phone number and global limiters of one endpoint, skipping the
+// ones that are disabled. `phone` extracts the phone number from the request body. The global limiter
+// comes last, so that only requests that pass the other limiters count against it and a single client
+// cannot use up the quota of everyone else. The chain is returned with no spare capacity, so routes
+// that share it can each append their own handler.
+func limitChain(store ratelimit.Store, name string, set configuration.LimitSet, phone func(*fiber.Ctx) string) ([]fiber.Handler, error) {
+	var chain []fiber.Handler
+	add := func(scope string, raw string, key func(*fiber.Ctx) string) error {
+		rule, err := ratelimit.ParseRule(raw)
+		if err != nil {
+			return err
+		}
+		if rule.Enabled() {
+			chain = append(chain, rateLimit(store, name+":"+scope, rule, key))
+		}
+		return nil
+	}
+	if err := add("ip", set.PerIP, func(c *fiber.Ctx) string { return c.IP() }); err != nil {
+		return nil, err
+	}
+	if err := add("phone", set.PerPhone, phone); err != nil {
+		return nil, err
+	}
+	if err := add("global", set.Global, func(c *fiber.Ctx) string { return "all" }); err != nil {
+		return nil, err
+	}
+	return chain[:len(chain):len(chain)], nil
+}
+
+// The function returns a middleware that allows at most `rule.Limit` requests per `rule.Window` for
+// every key returned by `key`. Requests over the limit are rejected with HTTP 429 and a `Retry-After`
+// header. Requests without a key are not counted, and if the store fails the request is let through so
+// that an outage of the store does not take the login endpoints down with it.
+func rateLimit(store ratelimit.Store, name string, rule ratelimit.Rule, key func(*fiber.Ctx) string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		k := key(c)
+		if k == "" {
+			return c.Next()
+		}
+		allowed, retryAfter, err := ratelimit.Allow(store, name+":"+k, rule)
+		if err != nil {
+			log.Printf("ratelimit: %s: %v", name, err)
+			return c.Next()
+		}
+		if !allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{"error": "too many requests, please try again later", "status": "failed"})
+		}
+		return c.Next()
+	}
+}
+
+// The function wraps a phone number extractor so that it returns the E.164 form of the number. Numbers
+// that cannot be normalized are counted as they are; the endpoint rejects them anyway.
+func normalizedPhone(extract func(*fiber.Ctx) string, region string) func(*fiber.Ctx) string {
+	return func(c *fiber.Ctx) string {
+		raw := extract(c)
+		if normalized, err := phone.Normalize(raw, region); err == nil {
+			return normalized
+		}
+		return raw
+	}
+}
+
+// The extractors below read the request body with `c.BodyParser`, like the handlers do, so that a
+// body the handler accepts is counted whatever its content type.
+
+// The function returns the phone number of a `/api/auth/sendotp` request body.
+func phoneFromOTPData(c *fiber.Ctx) string {
+	var body OTPData
+	c.BodyParser(&body)
+	return body.PhoneNumber
+}
+
+// The function returns the phone number of a request body that carries an OTP code. That is either a
+// `/api/auth/verifyotp` body, where the number is nested under `user`, or a flat body such as the one
+// of `/api/auth/password/verify`.
+func phoneFromVerifyData(c *fiber.Ctx) string {
+	var body struct {
+		User        *OTPData `json:"user"`
+		PhoneNumber string   `json:"phonenumber"`
+	}
+	if c.BodyParser(&body) != nil {
+		return ""
+	}
+	if body.User != nil {
+		return body.User.PhoneNumber
+	}
+	return body.PhoneNumber
+}
+
+// The function returns the lower cased email address of a `/api/auth/magic-link` request body.
+func emailFromMagicLinkBody(c *fiber.Ctx) string {
+	var body auth.MagicLinkBody
+	c.BodyParser(&body)
+	return strings.ToLower(strings.TrimSpace(body.Email))
+}
+
+// The function returns an extractor of the identifier of a `/api/auth/login` request body. Identifiers
+// are counted in the form they are looked up in, so that writing one differently does not get around
+// the per identifier limit; the limit is configured as the per phone number limit of the endpoint.
+func identifierFromAuthBody(region string) func(*fiber.Ctx) string {
+	return func(c *fiber.Ctx) string {
+		var body auth.AuthBody
+		c.BodyParser(&body)
+		raw := body.LoginIdentifier()
+		if id, ok := auth.ParseIdentifier(raw, region); ok {
+			return id.Kind + ":" + strings.ToLower(id.Value)
+		}
+		return raw
+	}
+}
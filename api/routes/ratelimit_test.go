@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sharir/pkg/configuration"
+	"sharir/pkg/ratelimit"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func newLimitedApp(t *testing.T, set configuration.LimitSet) *fiber.App {
+	t.Helper()
+	limits, err := NewRateLimits(ratelimit.NewMemoryStore(), configuration.RateLimits{
+		SendOTP: set, VerifyOTP: set, Login: set, MagicLink: set,
+	}, "US")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) }
+	app.Post("/api/auth/sendotp", append(limits.SendOTP, ok)...)
+	app.Post("/api/auth/verifyotp", append(limits.VerifyOTP, ok)...)
+	app.Post("/api/auth/login", append(limits.Login, ok)...)
+	app.Post("/api/auth/magic-link", append(limits.MagicLink, ok)...)
+	return app
+}
+
+func post(t *testing.T, app *fiber.App, path string, contentType string, body string, ip string) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, contentType)
+	req.Header.Set(fiber.HeaderXForwardedFor, ip)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestRateLimitCountsFormBodies(t *testing.T) {
+	app := newLimitedApp(t, configuration.LimitSet{PerPhone: "1/1m", PerIP: "0", Global: "0"})
+	cases := []struct {
+		path string
+		json string
+		form url.Values
+	}{
+		{"/api/auth/sendotp", `{"phoneNumber":"+14155550100"}`, url.Values{"phoneNumber": {"(415) 555-0100"}}},
+		{"/api/auth/verifyotp", `{"user":{"phoneNumber":"+14155550101"},"code":"1"}`, url.Values{"user.phoneNumber": {"415 555 0101"}, "code": {"1"}}},
+		{"/api/auth/login", `{"identifier":"someone","password":"x"}`, url.Values{"identifier": {"SomeOne"}, "password": {"x"}}},
+		{"/api/auth/magic-link", `{"email":"someone@example.com"}`, url.Values{"email": {"SomeOne@example.com"}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			if status := post(t, app, tc.path, fiber.MIMEApplicationForm, tc.form.Encode(), "192.0.2.1"); status != http.StatusOK {
+				t.Fatalf("first form request: status %d, want %d", status, http.StatusOK)
+			}
+			if status := post(t, app, tc.path, fiber.MIMEApplicationForm, tc.form.Encode(), "192.0.2.2"); status != http.StatusTooManyRequests {
+				t.Fatalf("second form request: status %d, want %d", status, http.StatusTooManyRequests)
+			}
+			// The JSON and the form body name the same phone number or identifier.
+			if status := post(t, app, tc.path, fiber.MIMEApplicationJSON, tc.json, "192.0.2.3"); status != http.StatusTooManyRequests {
+				t.Fatalf("JSON request: status %d, want %d", status, http.StatusTooManyRequests)
+			}
+		})
+	}
+}
+
+func TestRateLimitGlobalCountsOnlyAllowedRequests(t *testing.T) {
+	app := newLimitedApp(t, configuration.LimitSet{PerPhone: "0", PerIP: "1/1m", Global: "2/1m"})
+	body := `{"phoneNumber":"+14155550100"}`
+	for i := 0; i < 5; i++ {
+		post(t, app, "/api/auth/sendotp", fiber.MIMEApplicationJSON, body, "192.0.2.1")
+	}
+	if status := post(t, app, "/api/auth/sendotp", fiber.MIMEApplicationJSON, body, "192.0.2.2"); status != http.StatusOK {
+		t.Fatalf("request from another client: status %d, want %d", status, http.StatusOK)
+	}
+	if status := post(t, app, "/api/auth/sendotp", fiber.MIMEApplicationJSON, body, "192.0.2.3"); status != http.StatusTooManyRequests {
+		t.Fatalf("request over the global limit: status %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,74 @@
+package routes
+
+import (
+	"sharir/pkg/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The longest device name and user agent that are stored with a session.
+const (
+	maxDeviceNameLength = 100
+	maxUserAgentLength  = 512
+)
+
+// The function describes the device a request came from. Clients can name the device through the
+// `X-Device-Name` header.
+func deviceFromRequest(c *fiber.Ctx) auth.Device {
+	return auth.Device{
+		Name:      truncate(c.Get("X-Device-Name"), maxDeviceNameLength),
+		UserAgent: truncate(c.Get(fiber.HeaderUserAgent), maxUserAgentLength),
+		IP:        c.IP(),
+	}
+}
+
+// The function cuts `s` down to at most `n` bytes.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// The function handles requests for the sessions of the logged in user. The session the request was
+// made from is flagged as `current`.
+func ListSessionsHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := currentClaims(c)
+		sessions, err := svc.ListSessions(claims.UserID)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		out := make([]fiber.Map, 0, len(sessions))
+		for _, sess := range sessions {
+			out = append(out, fiber.Map{
+				"id":           sess.ID,
+				"device_name":  sess.DeviceName,
+				"user_agent":   sess.UserAgent,
+				"ip":           sess.IP,
+				"created_at":   sess.CreatedAt,
+				"last_seen_at": sess.LastSeenAt,
+				"current":      sess.ID == claims.FamilyID,
+			})
+		}
+		return c.Status(200).JSON(fiber.Map{"sessions": out, "status": "success"})
+	}
+}
+
+// The function handles requests to sign the logged in user out of one of their sessions.
+func RevokeSessionHandler(svc auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := svc.RevokeSession(currentClaims(c).UserID, c.Params("id")); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "status": "failed"})
+		}
+		return c.Status(200).JSON(fiber.Map{"status": "success"})
+	}
+}
+
+// The function creates the session management routes in a Fiber app. Both require a valid access
+// token.
+func CreateSessionRoutes(app *fiber.App, svc auth.Service) {
+	protected := JWTMiddleware(svc)
+	app.Get("/api/auth/sessions", protected, ListSessionsHandler(svc))
+	app.Delete("/api/auth/sessions/:id", protected, RevokeSessionHandler(svc))
+}
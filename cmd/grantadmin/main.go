@@ -0,0 +1,83 @@
+// Command grantadmin makes an existing user an admin. Roles are otherwise only changed by admins
+// through the API, so this is how the first admin is created, and how admins are restored after the
+// admin user types of older documents have been downgraded by the migration at startup. The
+// grant is recorded in the audit log with `cmd/grantadmin` as the actor. The user has to log in again
+// for the role to be in effect.
+//
+// The user is given by phone number, email address or username, the same identifiers the login
+// accepts. It connects to the database named by `MONGO_URI`, read from the environment or a `.env`
+// file:
+//
+//	go run ./cmd/grantadmin asha@example.com
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sharir/pkg"
+	"sharir/pkg/auth"
+	"sharir/pkg/configuration"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func main() {
+	godotenv.Load()
+	config := configuration.FromEnv()
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: grantadmin <phone number, email address or username>")
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	id, ok := auth.ParseIdentifier(flag.Arg(0), config.PhoneDefaultRegion)
+	if !ok {
+		log.Fatalf("cannot read identifier %q", flag.Arg(0))
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(config.MongoURI))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect(context.TODO())
+	db := client.Database("sharir")
+	repo := auth.NewRepo(db)
+
+	user, err := auth.ReadByIdentifier(repo, id)
+	if errors.Is(err, pkg.ErrUserNotFound) {
+		log.Fatalf("no user has the %s %s", id.Kind, id.Value)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if user.UserType == auth.RoleAdmin {
+		fmt.Printf("user %s is already an admin\n", user.ID)
+		return
+	}
+	if _, err := repo.Update(user.ID, map[string]interface{}{"$set": bson.M{"user_type": auth.RoleAdmin}}); err != nil {
+		log.Fatalf("updating user %s: %v", user.ID, err)
+	}
+	err = auth.NewAuditRepo(db).Create(auth.AuditEntry{
+		ID:        uuid.New().String(),
+		ActorID:   "cmd/grantadmin",
+		Action:    auth.AuditUserRole,
+		TargetID:  user.ID,
+		Details:   map[string]interface{}{"role": auth.RoleAdmin},
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Fatalf("recording the grant in the audit log: %v", err)
+	}
+	fmt.Printf("user %s is now an admin\n", user.ID)
+}
@@ -0,0 +1,96 @@
+// Command phonemigrate rewrites the phone numbers stored on users to the E.164 form the API uses since
+// numbers are normalized on every entry point. Numbers without a country code are read in
+// `PHONE_DEFAULT_REGION` unless `-region` is given.
+//
+// Numbers that cannot be read, and numbers that turn out to belong to another user once normalized,
+// are left as they are and reported; the latter have to be merged by hand. With `-dry-run` nothing is
+// written. Users are read in the current document layout, so the server has to have started once to
+// migrate older documents before the command is run:
+//
+//	go run ./cmd/phonemigrate -dry-run
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sharir/pkg"
+	"sharir/pkg/auth"
+	"sharir/pkg/configuration"
+	"sharir/pkg/phone"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func main() {
+	godotenv.Load()
+	config := configuration.FromEnv()
+	dryRun := flag.Bool("dry-run", false, "report the changes without writing them")
+	region := flag.String("region", config.PhoneDefaultRegion, "region of numbers without a country code")
+	flag.Parse()
+	if !phone.KnownRegion(*region) {
+		log.Fatalf("unknown region %q", *region)
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(config.MongoURI))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect(context.TODO())
+	repo := auth.NewRepo(client.Database("sharir"))
+
+	users, err := repo.ListWithPhoneNumber()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// `owner` remembers which user each normalized number belongs to, so that clashes between users
+	// are reported in a dry run too.
+	owner := map[string]string{}
+	for _, u := range users {
+		if normalized, err := phone.Normalize(u.PhoneNumber, *region); err == nil && normalized == u.PhoneNumber {
+			owner[normalized] = u.ID
+		}
+	}
+	var rewritten, unchanged, failed int
+	for _, u := range users {
+		normalized, err := phone.Normalize(u.PhoneNumber, *region)
+		if err != nil {
+			failed++
+			fmt.Printf("invalid\t%s\t%s\n", u.ID, u.PhoneNumber)
+			continue
+		}
+		if id, ok := owner[normalized]; ok && id != u.ID {
+			failed++
+			fmt.Printf("conflict\t%s\t%s\t%s\t%s\n", u.ID, u.PhoneNumber, normalized, id)
+			continue
+		}
+		owner[normalized] = u.ID
+		if normalized == u.PhoneNumber {
+			unchanged++
+			continue
+		}
+		if !*dryRun {
+			_, err := repo.Update(u.ID, map[string]interface{}{"$set": bson.M{"phone_number": normalized}})
+			if errors.Is(err, pkg.ErrPhoneNumberTaken) {
+				failed++
+				fmt.Printf("conflict\t%s\t%s\t%s\n", u.ID, u.PhoneNumber, normalized)
+				continue
+			}
+			if err != nil {
+				log.Fatalf("updating user %s: %v", u.ID, err)
+			}
+		}
+		rewritten++
+		fmt.Printf("rewrite\t%s\t%s\t%s\n", u.ID, u.PhoneNumber, normalized)
+	}
+	fmt.Printf("%d rewritten, %d already normalized, %d left for review\n", rewritten, unchanged, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
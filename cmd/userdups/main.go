@@ -0,0 +1,50 @@
+// Command userdups reports the users that share a phone number, email address or username. Such
+// duplicates keep the unique indexes on those fields from being built at startup, so they have to be
+// merged or cleaned up by hand first. The report is read-only. Users are read in the current document
+// layout; the server renames the fields of older documents at startup before it builds the indexes.
+//
+// It connects to the database named by `MONGO_URI`, read from the environment or a `.env` file:
+//
+//	go run ./cmd/userdups
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sharir/pkg/auth"
+	"sharir/pkg/configuration"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func main() {
+	godotenv.Load()
+	config := configuration.FromEnv()
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(config.MongoURI))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect(context.TODO())
+	repo := auth.NewRepo(client.Database("sharir"))
+
+	found := false
+	for _, field := range []string{"phone_number", "email", "username"} {
+		dups, err := repo.FindDuplicates(field)
+		if err != nil {
+			log.Fatalf("finding duplicate %s values: %v", field, err)
+		}
+		for _, d := range dups {
+			found = true
+			fmt.Printf("%s\t%s\t%s\n", field, d.Value, strings.Join(d.UserIDs, ","))
+		}
+	}
+	if found {
+		os.Exit(1)
+	}
+	fmt.Println("no duplicates found")
+}
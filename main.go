@@ -9,6 +9,10 @@ import (
 	"sharir/api/routes"
 	"sharir/pkg/auth"
 	"sharir/pkg/configuration"
+	"sharir/pkg/mail"
+	"sharir/pkg/otp"
+	"sharir/pkg/password"
+	"sharir/pkg/ratelimit"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -69,16 +73,145 @@ func main() {
 	// connection to the MongoDB database. The resulting `userRepo` variable is then used to pass the user
 	// data to the authentication routes defined in the `routes` package.
 	userRepo := auth.NewRepo(db)
-	userSvc := auth.NewAuthService(userRepo.(*auth.Repo))
+	// User documents written before the bson tags were added use other field names. They are renamed
+	// before the indexes are built, since the indexes cover the new names. Admin user types that
+	// older documents carry from before roles were enforced are downgraded; `go run ./cmd/grantadmin`
+	// grants the admin role again. Trainers are kept and logged for review.
+	migrated, err := userRepo.Migrate()
+	if err != nil {
+		log.Panicf("migrating user documents: %v", err)
+	}
+	if migrated > 0 {
+		log.Printf("migrated %d user documents to schema version %d", migrated, auth.UserSchemaVersion)
+	}
+	// The unique indexes on the phone number, email address and username can only be built once no two
+	// users share a value. `go run ./cmd/userdups` lists the accounts that have to be merged first.
+	if err := userRepo.EnsureIndexes(); err != nil {
+		log.Panicf("creating user indexes, run cmd/userdups to list duplicate accounts: %v", err)
+	}
+	// `tokenRepo` stores the hashes of issued refresh tokens so they can be rotated and revoked. Its
+	// indexes are created at startup so that expired tokens are removed by MongoDB automatically.
+	tokenRepo := auth.NewTokenRepo(db)
+	if err := tokenRepo.EnsureIndexes(); err != nil {
+		log.Panic(err)
+	}
+	// `revocationRepo` stores the `jti` of every access token that was revoked through logout. The JWT
+	// middleware checks it on every protected request.
+	revocationRepo := auth.NewRevocationRepo(db)
+	if err := revocationRepo.EnsureIndexes(); err != nil {
+		log.Panic(err)
+	}
+	// `sessionRepo` records every login with the device it came from, so users can see and end their
+	// sessions.
+	sessionRepo := auth.NewSessionRepo(db)
+	if err := sessionRepo.EnsureIndexes(); err != nil {
+		log.Panic(err)
+	}
+	// `auditRepo` keeps the audit log of every action admins take on user accounts.
+	auditRepo := auth.NewAuditRepo(db)
+	if err := auditRepo.EnsureIndexes(); err != nil {
+		log.Panic(err)
+	}
+	// `apiKeyRepo` stores the hashes of the API keys partners and batch jobs authenticate with.
+	apiKeyRepo := auth.NewAPIKeyRepo(db)
+	if err := apiKeyRepo.EnsureIndexes(); err != nil {
+		log.Panic(err)
+	}
+	// `magicLinkRepo` stores the hashes of the magic login links emailed to users. Expired links are
+	// removed by MongoDB.
+	magicLinkRepo := auth.NewMagicLinkRepo(db)
+	if err := magicLinkRepo.EnsureIndexes(); err != nil {
+		log.Panic(err)
+	}
+	// `passkeyRepo` stores the WebAuthn credentials users register to log in with a passkey.
+	passkeyRepo := auth.NewPasskeyRepo(db)
+	if err := passkeyRepo.EnsureIndexes(); err != nil {
+		log.Panic(err)
+	}
+	// `oidcRepo` keeps the state of social logins in progress and the provider accounts linked to
+	// users.
+	oidcRepo := auth.NewOIDCRepo(db)
+	if err := oidcRepo.EnsureIndexes(); err != nil {
+		log.Panic(err)
+	}
+
+	// `otpProvider` sends and verifies phone OTPs. Twilio Verify is used unless `OTP_PROVIDER` selects
+	// the self-hosted engine, which keeps the codes in MongoDB and only pays for the text message, or
+	// the fake provider, which only logs the codes and is meant for local development.
+	var otpProvider otp.OTPProvider
+	switch config.OTPProvider {
+	case "local":
+		otpRepo := otp.NewRepo(db)
+		if err := otpRepo.EnsureIndexes(); err != nil {
+			log.Panic(err)
+		}
+		var transport otp.SMSTransport = otp.LogTransport{}
+		if config.SMSTransport != "log" {
+			transport = otp.NewTwilioSMSTransport(config.TwilioAccountSID, config.TwilioAuthToken, config.TwilioFromNumber)
+		}
+		otpProvider = otp.NewEngine(otpRepo, transport, config)
+	case "fake":
+		otpProvider = otp.NewFakeProvider(config.OTPTTL, config.OTPMaxAttempts)
+	default:
+		otpProvider = otp.NewTwilioProvider(config.TwilioAccountSID, config.TwilioAuthToken, config.TwilioServiceID)
+	}
+	// Clients send phone numbers in any format, so they are normalized to E.164 before they reach the
+	// provider. Codes are then keyed by the same number whichever way it was typed.
+	otpProvider = otp.WithPhoneNormalization(otpProvider, config.PhoneDefaultRegion)
+	// `passwordPolicy` holds the rules new passwords are checked against at sign up and when they are
+	// reset or changed, including the breached password list named by `PASSWORD_BREACHED_LIST`.
+	passwordPolicy, err := password.NewPolicy(config)
+	if err != nil {
+		log.Panic(err)
+	}
+	// `passwordHasher` hashes passwords with the algorithm and cost selected by
+	// `PASSWORD_HASH_ALGORITHM`. An invalid setting stops the server here.
+	passwordHasher, err := password.NewHasher(config)
+	if err != nil {
+		log.Panic(err)
+	}
+	// `mailer` delivers emails such as verification links. Messages are only logged unless
+	// `MAIL_TRANSPORT` selects SMTP.
+	var mailer mail.Mailer = mail.NewCaptureMailer()
+	if config.MailTransport == "smtp" {
+		mailer = mail.NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword, config.MailFrom)
+	}
+	userSvc := auth.NewAuthService(userRepo, tokenRepo, revocationRepo, sessionRepo, passkeyRepo, oidcRepo, auditRepo, apiKeyRepo, magicLinkRepo, otpProvider, passwordPolicy, passwordHasher, mailer, config)
+
+	// `rateLimits` throttles the OTP and login endpoints per phone number, per IP address and globally.
+	// Counters are kept in memory unless `RATE_LIMIT_STORE` selects MongoDB, which is needed when more
+	// than one instance of the API is running.
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if config.RateLimitStore == "mongo" {
+		mongoStore := ratelimit.NewMongoStore(db)
+		if err := mongoStore.EnsureIndexes(); err != nil {
+			log.Panic(err)
+		}
+		rateLimitStore = mongoStore
+	}
+	rateLimits, err := routes.NewRateLimits(rateLimitStore, config.RateLimits, config.PhoneDefaultRegion)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	routes.CreatePhoneOtpRoutes(app, userSvc)
+	routes.CreatePhoneOtpRoutes(app, userSvc, otpProvider, rateLimits)
+	routes.CreatePasswordRoutes(app, userSvc, rateLimits)
+	routes.CreateEmailRoutes(app, userSvc)
+	routes.CreateMagicLinkRoutes(app, userSvc, rateLimits)
+	routes.CreateMFARoutes(app, userSvc, rateLimits)
+	routes.CreatePasskeyRoutes(app, userSvc, rateLimits)
+	routes.CreateOIDCRoutes(app, userSvc)
+	routes.CreateSessionRoutes(app, userSvc)
 	// `routes.CreateAuthRoutes(app, userRepo.(*auth.Repo))` is creating and registering HTTP routes
 	// related to user authentication in the Fiber application. It is passing the `app` instance of the
 	// Fiber application and a pointer to the `auth.Repo` struct instance `userRepo` to the
 	// `CreateAuthRoutes` function, which will define and register the necessary routes for user
 	// authentication. The `userRepo.(*auth.Repo)` syntax is used to convert the `userRepo` variable to a
 	// pointer to the `auth.Repo` struct type, which is required by the `CreateAuthRoutes` function.
-	routes.CreateAuthRoutes(app, userRepo.(*auth.Repo), userSvc)
+	routes.CreateAuthRoutes(app, userRepo.(*auth.Repo), userSvc, rateLimits)
+	// `routes.CreateAdminRoutes(app, userSvc)` registers the admin-only routes, such as unlocking an
+	// account that was locked after too many failed logins.
+	routes.CreateAdminRoutes(app, userSvc)
 	// `log.Panic(app.Listen(":" + os.Getenv("PORT")))` is starting the Fiber application and listening for
 	// incoming HTTP requests on the port specified in the `PORT` environment variable. If an error occurs
 	// while starting the application or listening for requests, the program will log the error and exit
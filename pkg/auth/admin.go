@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"sharir/pkg"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The audit log actions.
+const (
+	AuditUserList       = "user.list"
+	AuditUserView       = "user.view"
+	AuditUserSuspend    = "user.suspend"
+	AuditUserReactivate = "user.reactivate"
+	AuditUserRole       = "user.role"
+	AuditUserUnlock     = "user.unlock"
+	AuditUserDelete     = "user.delete"
+	AuditLogView        = "audit.view"
+)
+
+// The UserQuery type filters the users listed by `ListUsers`.
+// @property {string} Search - Matches the name, email address, phone number or username,
+// case-insensitively. Empty matches every user.
+// @property {string} Role - Only lists users with this role if set.
+// @property Page - The page to return.
+type UserQuery struct {
+	Search string
+	Role   string
+	Page   Page
+}
+
+// The UserPage type is one page of users.
+type UserPage struct {
+	Users []OutUser `json:"users"`
+	Page  int       `json:"page"`
+	Limit int       `json:"limit"`
+	Total int64     `json:"total"`
+}
+
+// The AuditPage type is one page of the audit log.
+type AuditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Page    int          `json:"page"`
+	Limit   int          `json:"limit"`
+	Total   int64        `json:"total"`
+}
+
+// The `ListUsers` function returns one page of the users matching the query, newest first.
+func (s *Svc) ListUsers(actor AccessClaims, q UserQuery) (UserPage, error) {
+	q.Page = q.Page.normalize()
+	users, total, err := s.repo.List(q.Search, q.Role, q.Page)
+	if err != nil {
+		return UserPage{}, err
+	}
+	if err := s.audit(actor, AuditUserList, "", map[string]interface{}{"search": q.Search, "role": q.Role, "page": q.Page.Number}); err != nil {
+		return UserPage{}, err
+	}
+	out := make([]OutUser, 0, len(users))
+	for _, u := range users {
+		out = append(out, u.ToOutUser())
+	}
+	return UserPage{Users: out, Page: q.Page.Number, Limit: q.Page.Size, Total: total}, nil
+}
+
+// The `GetUser` function returns a single user.
+func (s *Svc) GetUser(actor AccessClaims, userID string) (OutUser, error) {
+	user, err := s.repo.Read(userID)
+	if err != nil {
+		return OutUser{}, pkg.ErrUserNotFound
+	}
+	if err := s.audit(actor, AuditUserView, userID, nil); err != nil {
+		return OutUser{}, err
+	}
+	return user.ToOutUser(), nil
+}
+
+// The function reads the user an admin action is taken on. Requests made with an API key cannot act
+// on admins, so that a partner key cannot suspend or unlock the accounts that manage it.
+func (s *Svc) readTarget(actor AccessClaims, userID string) (User, error) {
+	user, err := s.repo.Read(userID)
+	if err != nil {
+		return User{}, pkg.ErrUserNotFound
+	}
+	if actor.APIKeyID != "" && roleOf(user) == RoleAdmin {
+		return User{}, pkg.ErrRoleNotAllowed
+	}
+	return user, nil
+}
+
+// The `SuspendUser` function suspends an account. A suspended user is signed out everywhere and
+// cannot log in until the account is reactivated. Admins cannot suspend themselves, and API keys
+// cannot suspend admins.
+func (s *Svc) SuspendUser(actor AccessClaims, userID string, reason string) error {
+	if actor.UserID == userID {
+		return pkg.ErrRoleNotAllowed
+	}
+	if _, err := s.readTarget(actor, userID); err != nil {
+		return err
+	}
+	if _, err := s.repo.Update(userID, map[string]interface{}{"$set": bson.M{"suspended": true, "suspended_at": time.Now()}}); err != nil {
+		return err
+	}
+	if err := s.revokeUserSessions(userID); err != nil {
+		return err
+	}
+	return s.audit(actor, AuditUserSuspend, userID, map[string]interface{}{"reason": reason})
+}
+
+// The `ReactivateUser` function lifts the suspension of an account. API keys cannot reactivate
+// admins.
+func (s *Svc) ReactivateUser(actor AccessClaims, userID string) error {
+	if _, err := s.readTarget(actor, userID); err != nil {
+		return err
+	}
+	if _, err := s.repo.Update(userID, map[string]interface{}{"$set": bson.M{"suspended": false}, "$unset": bson.M{"suspended_at": ""}}); err != nil {
+		return err
+	}
+	return s.audit(actor, AuditUserReactivate, userID, nil)
+}
+
+// The `DeleteUser` function removes an account together with its sessions, passkeys, linked identity
+// provider accounts and unused magic links. Admins cannot delete themselves.
+func (s *Svc) DeleteUser(actor AccessClaims, userID string) error {
+	if actor.UserID == userID {
+		return pkg.ErrRoleNotAllowed
+	}
+	deleted, err := s.repo.Delete(userID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return pkg.ErrUserNotFound
+	}
+	if err := s.revokeUserSessions(userID); err != nil {
+		return err
+	}
+	if err := s.passkeys.DeleteUser(userID); err != nil {
+		return err
+	}
+	if err := s.oidcRepo.DeleteUserIdentities(userID); err != nil {
+		return err
+	}
+	if err := s.magicLinks.DeleteUnused(userID); err != nil {
+		return err
+	}
+	return s.audit(actor, AuditUserDelete, userID, nil)
+}
+
+// The `ListAuditLog` function returns one page of the audit log, optionally only the entries about one
+// user. Reading the log is itself logged.
+func (s *Svc) ListAuditLog(actor AccessClaims, targetID string, page Page) (AuditPage, error) {
+	page = page.normalize()
+	entries, total, err := s.auditLog.List(targetID, page)
+	if err != nil {
+		return AuditPage{}, err
+	}
+	if err := s.audit(actor, AuditLogView, targetID, nil); err != nil {
+		return AuditPage{}, err
+	}
+	return AuditPage{Entries: entries, Page: page.Number, Limit: page.Size, Total: total}, nil
+}
+
+// The function records an admin action in the audit log. Actions taken with an API key are recorded
+// as `apikey:<id>`.
+func (s *Svc) audit(actor AccessClaims, action string, targetID string, details map[string]interface{}) error {
+	actorID := actor.UserID
+	if actor.APIKeyID != "" {
+		actorID = "apikey:" + actor.APIKeyID
+	}
+	return s.auditLog.Create(AuditEntry{
+		ID:        uuid.New().String(),
+		ActorID:   actorID,
+		Action:    action,
+		TargetID:  targetID,
+		Details:   details,
+		CreatedAt: time.Now(),
+	})
+}
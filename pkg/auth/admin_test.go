@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"sharir/pkg"
+	"testing"
+)
+
+func TestAPIKeyCannotActOnAdmins(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.addUser(t, User{Name: "Admin", UserType: RoleAdmin}, "")
+	client := env.addUser(t, User{Name: "Asha", PhoneNumber: testPhone}, "")
+	key := AccessClaims{APIKeyID: "partner", Scopes: []string{ScopeUsersWrite}}
+	actions := map[string]func(AccessClaims, string) error{
+		"suspend":    func(actor AccessClaims, id string) error { return env.svc.SuspendUser(actor, id, "test") },
+		"reactivate": env.svc.ReactivateUser,
+		"unlock":     env.svc.UnlockUser,
+	}
+	for name, action := range actions {
+		if err := action(key, admin.ID); err != pkg.ErrRoleNotAllowed {
+			t.Errorf("%s of an admin with an API key: got %v", name, err)
+		}
+		if err := action(key, client.ID); err != nil {
+			t.Errorf("%s of a client with an API key: got %v", name, err)
+		}
+	}
+	if env.user(t, admin.ID).Suspended {
+		t.Error("admin suspended with an API key")
+	}
+	adminClaims := AccessClaims{UserID: "other-admin", Role: RoleAdmin}
+	if err := env.svc.SuspendUser(adminClaims, admin.ID, "test"); err != nil {
+		t.Errorf("suspending an admin as an admin: got %v", err)
+	}
+}
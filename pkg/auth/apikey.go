@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"sharir/pkg"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The scopes an API key can be given. Each scope opens a group of admin endpoints to the key.
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeAuditRead  = "audit:read"
+)
+
+// The audit log actions of API key management.
+const (
+	AuditAPIKeyCreate = "apikey.create"
+	AuditAPIKeyRevoke = "apikey.revoke"
+)
+
+// `apiKeyPrefix` starts every API key so that keys are easy to recognise, for example by secret
+// scanners.
+const apiKeyPrefix = "shk_"
+
+// `apiKeyUseInterval` is how often the last use of a key is written. Keys used by batch jobs can see
+// many requests a second, so the time is only kept to this precision.
+const apiKeyUseInterval = time.Minute
+
+// The function reports whether `scope` is one of the defined scopes.
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeUsersRead, ScopeUsersWrite, ScopeAuditRead:
+		return true
+	}
+	return false
+}
+
+// The APIKeyPage type is one page of API keys.
+type APIKeyPage struct {
+	Keys  []APIKey `json:"keys"`
+	Page  int      `json:"page"`
+	Limit int      `json:"limit"`
+	Total int64    `json:"total"`
+}
+
+// The `CreateAPIKey` function creates an API key with the given scopes. A zero `expiresAt` creates a
+// key that does not expire. The key itself is returned together with the stored document; it cannot be
+// read back later.
+func (s *Svc) CreateAPIKey(actor AccessClaims, name string, scopes []string, expiresAt time.Time) (string, APIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", APIKey{}, pkg.ErrInvalidAPIKeyRequest
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		return "", APIKey{}, pkg.ErrInvalidAPIKeyRequest
+	}
+	if len(scopes) == 0 {
+		return "", APIKey{}, pkg.ErrInvalidScope
+	}
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !ValidScope(scope) {
+			return "", APIKey{}, pkg.ErrInvalidScope
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			unique = append(unique, scope)
+		}
+	}
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	raw := apiKeyPrefix + secret
+	key := APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Prefix:    raw[:len(apiKeyPrefix)+6],
+		Hash:      hashToken(raw),
+		Scopes:    unique,
+		CreatedBy: actor.UserID,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err := s.apiKeys.Create(key); err != nil {
+		return "", APIKey{}, err
+	}
+	if err := s.audit(actor, AuditAPIKeyCreate, "", map[string]interface{}{"key_id": key.ID, "name": name, "scopes": unique}); err != nil {
+		return "", APIKey{}, err
+	}
+	return raw, key, nil
+}
+
+// The `ListAPIKeys` function returns one page of API keys, newest first.
+func (s *Svc) ListAPIKeys(actor AccessClaims, page Page) (APIKeyPage, error) {
+	page = page.normalize()
+	keys, total, err := s.apiKeys.List(page)
+	if err != nil {
+		return APIKeyPage{}, err
+	}
+	return APIKeyPage{Keys: keys, Page: page.Number, Limit: page.Size, Total: total}, nil
+}
+
+// The `RevokeAPIKey` function revokes an API key. It stops working immediately.
+func (s *Svc) RevokeAPIKey(actor AccessClaims, id string) error {
+	found, err := s.apiKeys.Revoke(id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return pkg.ErrAPIKeyNotFound
+	}
+	return s.audit(actor, AuditAPIKeyRevoke, "", map[string]interface{}{"key_id": id})
+}
+
+// The `AuthenticateAPIKey` function checks an API key presented in the `X-API-Key` header and returns
+// the claims the request is made with. The claims carry the key's ID and scopes and no user.
+func (s *Svc) AuthenticateAPIKey(raw string) (AccessClaims, error) {
+	if !strings.HasPrefix(raw, apiKeyPrefix) {
+		return AccessClaims{}, pkg.ErrInvalidAPIKey
+	}
+	key, err := s.apiKeys.ReadByHash(hashToken(raw))
+	if err == mongo.ErrNoDocuments {
+		return AccessClaims{}, pkg.ErrInvalidAPIKey
+	}
+	if err != nil {
+		return AccessClaims{}, err
+	}
+	now := time.Now()
+	if key.Revoked || (!key.ExpiresAt.IsZero() && now.After(key.ExpiresAt)) {
+		return AccessClaims{}, pkg.ErrInvalidAPIKey
+	}
+	if now.Sub(key.LastUsedAt) >= apiKeyUseInterval {
+		if err := s.apiKeys.RecordUse(key.ID, now); err != nil {
+			return AccessClaims{}, err
+		}
+	}
+	return AccessClaims{APIKeyID: key.ID, Scopes: key.Scopes}, nil
+}
+
+// The function reports whether the claims of an API key request include `scope`.
+func (c AccessClaims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
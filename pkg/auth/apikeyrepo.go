@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The APIKey type is the document stored in the `api_keys` collection for every API key. Only the
+// SHA-256 hash of the key is persisted; the key itself is shown once when it is created.
+// @property {string} ID - A unique ID of the key, used to manage it.
+// @property {string} Name - A name that tells admins who the key was issued to, such as the partner
+// gym.
+// @property {string} Prefix - The first characters of the key, so that a key can be recognised in the
+// list without storing it.
+// @property {string} Hash - The hex encoded SHA-256 hash of the key.
+// @property {[]string} Scopes - What the key may be used for, such as "users:read".
+// @property {string} CreatedBy - The ID of the admin that created the key.
+// @property {bool} Revoked - Set when an admin revokes the key.
+// @property ExpiresAt - The time after which the key is no longer accepted. Keys without an expiry
+// have the zero time.
+// @property LastUsedAt - The last time the key was used, to the minute.
+// @property CreatedAt - The time the key was created.
+type APIKey struct {
+	ID         string    `bson:"_id" json:"id"`
+	Name       string    `bson:"name" json:"name"`
+	Prefix     string    `bson:"prefix" json:"prefix"`
+	Hash       string    `bson:"hash" json:"-"`
+	Scopes     []string  `bson:"scopes" json:"scopes"`
+	CreatedBy  string    `bson:"created_by" json:"created_by"`
+	Revoked    bool      `bson:"revoked" json:"revoked"`
+	ExpiresAt  time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt time.Time `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
+
+// APIKeyRepository defines the operations that can be performed on stored API keys.
+type APIKeyRepository interface {
+	Create(key APIKey) error
+	ReadByHash(hash string) (APIKey, error)
+	List(page Page) ([]APIKey, int64, error)
+	RecordUse(id string, at time.Time) error
+	Revoke(id string) (bool, error)
+	EnsureIndexes() error
+}
+
+// APIKeyRepo is the struct that implements the APIKeyRepository interface on top of the `api_keys`
+// collection. To create an APIKeyRepo, use the NewAPIKeyRepo function.
+type APIKeyRepo struct {
+	db      *mongo.Collection
+	context context.Context
+}
+
+// The function stores a new API key.
+func (s *APIKeyRepo) Create(key APIKey) error {
+	_, err := s.db.InsertOne(s.context, key)
+	return err
+}
+
+// The function returns the API key with the given hash.
+func (s *APIKeyRepo) ReadByHash(hash string) (APIKey, error) {
+	var key APIKey
+	err := s.db.FindOne(s.context, bson.M{"hash": hash}).Decode(&key)
+	return key, err
+}
+
+// The function returns one page of API keys, newest first, together with the total number of keys.
+func (s *APIKeyRepo) List(page Page) ([]APIKey, int64, error) {
+	total, err := s.db.CountDocuments(s.context, bson.M{})
+	if err != nil {
+		return nil, 0, err
+	}
+	cur, err := s.db.Find(s.context, bson.M{}, page.findOptions().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, 0, err
+	}
+	keys := []APIKey{}
+	if err := cur.All(s.context, &keys); err != nil {
+		return nil, 0, err
+	}
+	return keys, total, nil
+}
+
+// The function records the time the key was last used.
+func (s *APIKeyRepo) RecordUse(id string, at time.Time) error {
+	_, err := s.db.UpdateByID(s.context, id, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
+
+// The function revokes the API key with the given ID and reports whether the key exists.
+func (s *APIKeyRepo) Revoke(id string) (bool, error) {
+	res, err := s.db.UpdateByID(s.context, id, bson.M{"$set": bson.M{"revoked": true}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// The function creates the unique index on the key hash that every authenticated request looks keys up
+// by, and the index used to list keys.
+func (s *APIKeyRepo) EnsureIndexes() error {
+	_, err := s.db.Indexes().CreateMany(s.context, []mongo.IndexModel{
+		{Keys: bson.M{"hash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"created_at": -1}},
+	})
+	return err
+}
+
+// The function returns a new instance of an APIKeyRepository interface implementation with a MongoDB
+// database connection.
+func NewAPIKeyRepo(db *mongo.Database) APIKeyRepository {
+	ctx := context.TODO()
+	return &APIKeyRepo{db: db.Collection("api_keys"), context: ctx}
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The AuditEntry type is the document stored in the `audit_log` collection for every action an admin
+// takes on a user account.
+// @property {string} ID - A unique ID of the entry.
+// @property {string} ActorID - The ID of the admin that took the action, or `apikey:<id>` for actions
+// taken with an API key.
+// @property {string} Action - What was done, such as "user.suspend".
+// @property {string} TargetID - The ID of the user the action was taken on. It is empty for actions on
+// no single user, such as listing users.
+// @property Details - Additional information about the action, such as the new role.
+// @property CreatedAt - The time the action was taken.
+type AuditEntry struct {
+	ID        string                 `bson:"_id" json:"id"`
+	ActorID   string                 `bson:"actor_id" json:"actor_id"`
+	Action    string                 `bson:"action" json:"action"`
+	TargetID  string                 `bson:"target_id,omitempty" json:"target_id,omitempty"`
+	Details   map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// AuditRepository defines the operations that can be performed on the audit log. Entries are never
+// changed or removed.
+type AuditRepository interface {
+	Create(entry AuditEntry) error
+	List(targetID string, page Page) ([]AuditEntry, int64, error)
+	EnsureIndexes() error
+}
+
+// AuditRepo is the struct that implements the AuditRepository interface on top of the `audit_log`
+// collection. To create an AuditRepo, use the NewAuditRepo function.
+type AuditRepo struct {
+	db      *mongo.Collection
+	context context.Context
+}
+
+// The function appends an entry to the audit log.
+func (s *AuditRepo) Create(entry AuditEntry) error {
+	_, err := s.db.InsertOne(s.context, entry)
+	return err
+}
+
+// The function returns one page of the audit log, newest first, together with the total number of
+// entries. If `targetID` is not empty only the entries about that user are returned.
+func (s *AuditRepo) List(targetID string, page Page) ([]AuditEntry, int64, error) {
+	filter := bson.M{}
+	if targetID != "" {
+		filter["target_id"] = targetID
+	}
+	total, err := s.db.CountDocuments(s.context, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cur, err := s.db.Find(s.context, filter, page.findOptions().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, 0, err
+	}
+	entries := []AuditEntry{}
+	if err := cur.All(s.context, &entries); err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// The function creates the indexes used to list the log as a whole and per user.
+func (s *AuditRepo) EnsureIndexes() error {
+	_, err := s.db.Indexes().CreateMany(s.context, []mongo.IndexModel{
+		{Keys: bson.M{"created_at": -1}},
+		{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// The function returns a new instance of an AuditRepository interface implementation with a MongoDB
+// database connection.
+func NewAuditRepo(db *mongo.Database) AuditRepository {
+	ctx := context.TODO()
+	return &AuditRepo{db: db.Collection("audit_log"), context: ctx}
+}
+
+// The Page type selects one page of a paginated list.
+// @property {int} Number - The page number, starting at 1.
+// @property {int} Size - The number of items per page.
+type Page struct {
+	Number int
+	Size   int
+}
+
+// The defaults and limits of pagination.
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// The function returns the page with out of range values replaced by the defaults.
+func (p Page) normalize() Page {
+	if p.Number < 1 {
+		p.Number = 1
+	}
+	if p.Size < 1 {
+		p.Size = DefaultPageSize
+	}
+	if p.Size > MaxPageSize {
+		p.Size = MaxPageSize
+	}
+	return p
+}
+
+// The function returns the find options that select the page.
+func (p Page) findOptions() *options.FindOptions {
+	p = p.normalize()
+	return options.Find().SetSkip(int64((p.Number - 1) * p.Size)).SetLimit(int64(p.Size))
+}
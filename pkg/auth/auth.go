@@ -9,14 +9,95 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// The AuthBody type is the request body of `/api/auth/login`.
+// @property {string} Identifier - The phone number, email address or username of the user.
+// @property {string} PhoneNumber - The phone number of the user. It is read when `Identifier` is
+// empty, so that clients that only send a phone number keep working.
+// @property {string} Password - The password of the user.
 type AuthBody struct {
+	Identifier  string `json:"identifier"`
 	PhoneNumber string `json:"phonenumber"`
 	Password    string `json:"password"`
 }
 
+// The function returns the identifier the user logs in with.
+func (b AuthBody) LoginIdentifier() string {
+	if b.Identifier != "" {
+		return b.Identifier
+	}
+	return b.PhoneNumber
+}
+
+// The RefreshBody type is the request body of `/api/auth/refresh`.
+// @property {string} RefreshToken - The refresh token that was returned by the last login or refresh.
+type RefreshBody struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// The ForgotPasswordBody type is the request body of `/api/auth/password/forgot`.
+// @property {string} PhoneNumber - The phone number of the account whose password was forgotten.
+type ForgotPasswordBody struct {
+	PhoneNumber string `json:"phonenumber"`
+}
+
+// The VerifyResetBody type is the request body of `/api/auth/password/verify`.
+// @property {string} PhoneNumber - The phone number the reset OTP was sent to.
+// @property {string} Code - The OTP the user received.
+type VerifyResetBody struct {
+	PhoneNumber string `json:"phonenumber"`
+	Code        string `json:"code"`
+}
+
+// The ResetPasswordBody type is the request body of `/api/auth/password/reset`.
+// @property {string} ResetToken - The reset token returned by `/api/auth/password/verify`.
+// @property {string} Password - The new password.
+type ResetPasswordBody struct {
+	ResetToken string `json:"reset_token"`
+	Password   string `json:"password"`
+}
+
+// The ChangePasswordBody type is the request body of `/api/auth/password/change`.
+// @property {string} CurrentPassword - The password the user has now.
+// @property {string} NewPassword - The password the user wants to have.
+type ChangePasswordBody struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// The TOTPCodeBody type is the request body of the TOTP confirm and disable endpoints.
+// @property {string} Code - A TOTP code from the authenticator app, or a recovery code.
+type TOTPCodeBody struct {
+	Code string `json:"code"`
+}
+
+// The MFABody type is the request body of `/api/auth/2fa/verify`.
+// @property {string} MFAToken - The challenge token returned by the login.
+// @property {string} Code - A TOTP code from the authenticator app, or a recovery code.
+type MFABody struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// The MagicLinkBody type is the request body of `/api/auth/magic-link`.
+// @property {string} Email - The email address to send the login link to.
+type MagicLinkBody struct {
+	Email string `json:"email"`
+}
+
+// The RedeemMagicLinkBody type is the request body of `/api/auth/magic-link/redeem`.
+// @property {string} Token - The token from the `token` query parameter of the emailed link.
+type RedeemMagicLinkBody struct {
+	Token string `json:"token"`
+}
+
+// The VerifyEmailBody type is the request body of `POST /api/auth/email/verify`.
+// @property {string} Token - The token from the `token` query parameter of the emailed link.
+type VerifyEmailBody struct {
+	Token string `json:"token"`
+}
+
 // The above type defines a user with various properties such as ID, name, password, phone number,
 // email, and gender.
 // @property {string} ID - A unique identifier for the user, typically stored as a string.
@@ -31,9 +112,7 @@ type AuthBody struct {
 // @property {string} Username - The username property is a string that represents the unique username
 // of a user. It is used for authentication and identification purposes.
 // @property {string} UserType - UserType is a property of the User struct that represents the type of
-// user. It can be used to differentiate between different types of users, such as regular users,
-// administrators, or moderators. The value of UserType can be set to any string that represents the
-// type of user.
+// user. It holds the user's role, one of `RoleClient`, `RoleTrainer` or `RoleAdmin`.
 // @property {string} DateOfBirth - This property represents the date of birth of a user. It is stored
 // as a string in the format of "YYYY-MM-DD".
 // @property {string} Gender - The gender of the user. It can be a string value such as "male",
@@ -41,21 +120,57 @@ type AuthBody struct {
 // @property CreatedAt - CreatedAt is a property of the User struct that represents the date and time
 // when the user was created. It is of type time.Time and is formatted as "YYYY-MM-DD HH:MM:SS". This
 // property can be used to track when a user was added to a system or database.
-
+// @property {bool} PhoneVerified - PhoneVerified is set once the user has proven ownership of the
+// phone number by verifying an OTP sent to it.
+// @property {int} FailedLogins - The number of consecutive failed password logins. It is reset by a
+// successful login or an admin unlock.
+// @property LockedUntil - The time until which password logins are refused after too many failures.
+// @property {bool} EmailVerified - EmailVerified is set once the user has opened the verification link
+// sent to the email address.
+// @property {bool} TOTPEnabled - TOTPEnabled is set once the user has confirmed TOTP enrollment. Password
+// logins then need a second factor.
+// @property {string} TOTPSecret - The base32 TOTP secret shared with the authenticator app.
+// @property {string} TOTPPendingSecret - The secret of an enrollment that has not been confirmed yet.
+// @property {int64} TOTPLastStep - The last TOTP time step that was accepted, so codes cannot be
+// replayed.
+// @property {[]string} RecoveryCodes - The SHA-256 hashes of the unused recovery codes.
+// @property {bool} Suspended - Suspended is set by an admin to keep the user from logging in.
+// @property SuspendedAt - The time the user was suspended.
+// @property {int} SchemaVersion - The version of the document layout, see `UserSchemaVersion`.
+//
+// The bson tags are the persisted schema of the `users` collection; every query on the collection
+// uses these field names.
 type User struct {
-	ID          string    `json:"id" bson:"_id"`
-	Name        string    `json:"name"`
-	Password    string    `json:"password"`
-	PhoneNumber string    `json:"phone_number"`
-	ProfilePic  string    `json:"profile_pic"`
-	Email       string    `json:"email"`
-	Username    string    `json:"username"`
-	UserType    string    `json:"usertype"`
-	DateOfBirth string    `json:"dob"`
-	Gender      string    `json:"gender"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID                string    `json:"id" bson:"_id"`
+	Name              string    `json:"name" bson:"name"`
+	Password          string    `json:"password" bson:"password"`
+	PhoneNumber       string    `json:"phone_number" bson:"phone_number"`
+	ProfilePic        string    `json:"profile_pic" bson:"profile_pic"`
+	Email             string    `json:"email" bson:"email"`
+	Username          string    `json:"username" bson:"username"`
+	UserType          string    `json:"usertype" bson:"user_type"`
+	DateOfBirth       string    `json:"dob" bson:"date_of_birth"`
+	Gender            string    `json:"gender" bson:"gender"`
+	CreatedAt         time.Time `json:"created_at" bson:"created_at"`
+	PhoneVerified     bool      `json:"phone_verified" bson:"phone_verified"`
+	FailedLogins      int       `json:"failed_logins" bson:"failed_logins"`
+	LockedUntil       time.Time `json:"locked_until" bson:"locked_until"`
+	EmailVerified     bool      `json:"email_verified" bson:"email_verified"`
+	TOTPEnabled       bool      `json:"totp_enabled" bson:"totp_enabled"`
+	TOTPSecret        string    `json:"-" bson:"totp_secret,omitempty"`
+	TOTPPendingSecret string    `json:"-" bson:"totp_pending_secret,omitempty"`
+	TOTPLastStep      int64     `json:"-" bson:"totp_last_step,omitempty"`
+	RecoveryCodes     []string  `json:"-" bson:"recovery_codes,omitempty"`
+	Suspended         bool      `json:"suspended" bson:"suspended"`
+	SuspendedAt       time.Time `json:"suspended_at" bson:"suspended_at,omitempty"`
+	SchemaVersion     int       `json:"-" bson:"schema_version"`
 }
 
+// `UserSchemaVersion` is the version of the user document layout written by this code. Version 1 is
+// the layout from before the bson tags, where field names were the lower cased Go names such as
+// `phonenumber`, and user types were chosen at sign up; `Repo.Migrate` upgrades those documents.
+const UserSchemaVersion = 2
+
 // The above type defines the structure of an input user object in Go, with various fields such as
 // name, password, phone number, email, and gender.
 // @property {string} Name - The name of the user.
@@ -81,9 +196,7 @@ type User struct {
 // contexts, including social, medical, and legal. In the context of the InUser struct, it is a
 // property that stores the gender of a user.
 // @property {string} UserType - UserType is a property of the InUser struct that represents the type
-// of user. It can be used to differentiate between different types of users, such as regular users,
-// administrators, or moderators. The value of UserType can be set to any string that represents the
-// type of user.
+// of user. It may be left empty or set to "client"; privileged roles are granted by an admin.
 type InUser struct {
 	Name        string `json:"name"`
 	Password    string `json:"password"`
@@ -115,24 +228,29 @@ type InUser struct {
 // gender identity.
 // @property CreatedAt - CreatedAt is a property of the OutUser struct that represents the date and
 // time when the user was created. It is of type time.Time and is formatted as "YYYY-MM-DD HH:MM:SS".
+// @property {bool} PhoneVerified - Whether the user has verified the phone number with an OTP.
+// @property {bool} EmailVerified - Whether the user has verified the email address.
+// @property {bool} Suspended - Whether an admin has suspended the user.
 type OutUser struct {
-	ID          string    `json:"id" bson:"_id"`
-	Name        string    `json:"name"`
-	Email       string    `json:"email"`
-	PhoneNumber string    `json:"phone_number"`
-	ProfilePic  string    `json:"profile_pic"`
-	UserType    string    `json:"user_type"`
-	Username    string    `json:"username"`
-	DateOfBirth string    `json:"dob"`
-	Gender      string    `json:"gender"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID            string    `json:"id" bson:"_id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	PhoneNumber   string    `json:"phone_number"`
+	ProfilePic    string    `json:"profile_pic"`
+	UserType      string    `json:"user_type"`
+	Username      string    `json:"username"`
+	DateOfBirth   string    `json:"dob"`
+	Gender        string    `json:"gender"`
+	CreatedAt     time.Time `json:"created_at"`
+	PhoneVerified bool      `json:"phone_verified"`
+	EmailVerified bool      `json:"email_verified"`
+	Suspended     bool      `json:"suspended"`
 }
 
 // The `ToUser()` function is a method of the `InUser` struct that converts an input user object of
-// type `InUser` to an output user object of type `User`. It generates a new UUID for the user ID,
-// hashes the user's password using the `hashPassword()` function, and sets the remaining user
-// properties based on the input `InUser` object. The function returns a new `User` object with the
-// generated UUID and hashed password, along with the other user properties.
+// type `InUser` to an output user object of type `User`. It generates a new UUID for the user ID and
+// sets the remaining user properties based on the input `InUser` object. The password is copied as it
+// is, so `in.Password` must already hold the hash made by the service's password hasher.
 func (in *InUser) ToUser() User {
 	uuid := uuid.New().String()
 	return User{
@@ -140,7 +258,7 @@ func (in *InUser) ToUser() User {
 		Name:        in.Name,
 		ProfilePic:  in.ProfilePic,
 		PhoneNumber: in.PhoneNumber,
-		Password:    hashPassword(in.Password),
+		Password:    in.Password,
 		Email:       in.Email,
 		UserType:    in.UserType,
 		Username:    in.Username,
@@ -157,22 +275,30 @@ func (in *InUser) ToUser() User {
 // corresponding properties of the `User` object. The resulting `OutUser` object is then returned.
 func (u *User) ToOutUser() OutUser {
 	return OutUser{
-		ID:          u.ID,
-		Name:        u.Name,
-		ProfilePic:  u.ProfilePic,
-		PhoneNumber: u.PhoneNumber,
-		UserType:    u.UserType,
-		Email:       u.Email,
-		Username:    u.Username,
-		DateOfBirth: u.DateOfBirth,
-		Gender:      u.Gender,
-		CreatedAt:   u.CreatedAt,
+		ID:            u.ID,
+		Name:          u.Name,
+		ProfilePic:    u.ProfilePic,
+		PhoneNumber:   u.PhoneNumber,
+		UserType:      u.UserType,
+		Email:         u.Email,
+		Username:      u.Username,
+		DateOfBirth:   u.DateOfBirth,
+		Gender:        u.Gender,
+		CreatedAt:     u.CreatedAt,
+		PhoneVerified: u.PhoneVerified,
+		EmailVerified: u.EmailVerified,
+		Suspended:     u.Suspended,
 	}
 }
 
-// The function takes a password string, generates a hash using bcrypt algorithm with minimum cost, and
-// returns the hash as a string.
-func hashPassword(password string) string {
-	bytes, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
-	return string(bytes)
+// The function returns a minimal user for a phone number that has just been verified with an OTP. The
+// user has no password and can complete the rest of the profile later.
+func newPhoneUser(phone string) User {
+	return User{
+		ID:            uuid.New().String(),
+		PhoneNumber:   phone,
+		PhoneVerified: true,
+		UserType:      RoleClient,
+		CreatedAt:     time.Now(),
+	}
 }
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"fmt"
+	"net/url"
+	"sharir/pkg"
+	"sharir/pkg/mail"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The `SendEmailVerification` function emails a signed verification link to the address of the user
+// with the given ID. The link carries a single-use grant that is bound to the address, so it stops
+// working if the address changes before it is used.
+func (s *Svc) SendEmailVerification(userID string) error {
+	user, err := s.repo.Read(userID)
+	if err != nil {
+		return pkg.ErrUserNotFound
+	}
+	if user.Email == "" {
+		return pkg.ErrEmailRequired
+	}
+	if user.EmailVerified {
+		return pkg.ErrEmailAlreadyVerified
+	}
+	token, err := s.signGrant(user.ID, PurposeEmailVerification, s.config.EmailVerificationTTL, jwt.MapClaims{"email": user.Email})
+	if err != nil {
+		return err
+	}
+	link := fmt.Sprintf("%s/api/auth/email/verify?token=%s", strings.TrimSuffix(s.config.AppBaseURL, "/"), url.QueryEscape(token))
+	return s.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link:\n\n%s\n\nThe link expires in %s.\n", user.Name, link, s.config.EmailVerificationTTL),
+	})
+}
+
+// The `VerifyEmail` function consumes an email verification grant and marks the user's email as
+// verified. The grant is rejected if the user's email is no longer the address it was issued for.
+func (s *Svc) VerifyEmail(token string) error {
+	claims, err := s.consumeGrant(token, PurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+	user, err := s.repo.Read(claims.UserID)
+	if err != nil || user.Email == "" || user.Email != claims.Email {
+		return pkg.ErrInvalidGrant
+	}
+	_, err = s.repo.Update(user.ID, map[string]interface{}{"$set": bson.M{"email_verified": true}})
+	return err
+}
+
+// The `IsEmailVerified` function reports whether the user with the given ID has verified the email
+// address.
+func (s *Svc) IsEmailVerified(userID string) (bool, error) {
+	user, err := s.repo.Read(userID)
+	if err != nil {
+		return false, pkg.ErrUserNotFound
+	}
+	return user.EmailVerified, nil
+}
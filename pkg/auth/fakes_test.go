@@ -0,0 +1,670 @@
+package auth
+
+import (
+	"sharir/pkg"
+	"sharir/pkg/configuration"
+	"sharir/pkg/mail"
+	"sharir/pkg/otp"
+	"sharir/pkg/password"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The fakes in this file keep their documents in memory and follow the MongoDB repositories closely
+// enough for the service tests: users are stored as BSON documents, so updates are applied to the
+// same field names the real queries use. Repository methods a fake does not implement panic through
+// the embedded nil interface.
+
+// memUsers is an in-memory Repository.
+type memUsers struct {
+	Repository
+	mu   sync.Mutex
+	docs map[string]bson.M
+}
+
+func newMemUsers() *memUsers {
+	return &memUsers{docs: map[string]bson.M{}}
+}
+
+func toDoc(user User) bson.M {
+	raw, err := bson.Marshal(user)
+	if err != nil {
+		panic(err)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+func fromDoc(doc bson.M) User {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+	var user User
+	if err := bson.Unmarshal(raw, &user); err != nil {
+		panic(err)
+	}
+	return user
+}
+
+func (r *memUsers) Create(in InUser) (User, error) {
+	return r.Insert(in.ToUser())
+}
+
+func (r *memUsers) Insert(user User) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user.SchemaVersion = UserSchemaVersion
+	doc := toDoc(user)
+	for _, idx := range uniqueUserIndexes {
+		value, _ := doc[idx.field].(string)
+		if value != "" && r.find(idx.field, value) != nil {
+			return user, idx.err
+		}
+	}
+	r.docs[user.ID] = doc
+	return user, nil
+}
+
+// find returns the document whose field equals value case-insensitively, like the collation of the
+// real lookups.
+func (r *memUsers) find(field string, value string) bson.M {
+	for _, doc := range r.docs {
+		if v, _ := doc[field].(string); v != "" && strings.EqualFold(v, value) {
+			return doc
+		}
+	}
+	return nil
+}
+
+func (r *memUsers) readBy(field string, value string) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc := r.find(field, value)
+	if doc == nil {
+		return User{}, pkg.ErrUserNotFound
+	}
+	return fromDoc(doc), nil
+}
+
+func (r *memUsers) Read(id string) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, ok := r.docs[id]
+	if !ok {
+		return User{}, pkg.ErrUserNotFound
+	}
+	return fromDoc(doc), nil
+}
+
+func (r *memUsers) ReadByID(id string) (User, error) {
+	return r.Read(id)
+}
+
+func (r *memUsers) ReadByEmail(email string) (User, error) {
+	return r.readBy("email", email)
+}
+
+func (r *memUsers) ReadByPhoneNumber(phone string) (User, error) {
+	return r.readBy("phone_number", phone)
+}
+
+func (r *memUsers) ReadByUsernanme(username string) (User, error) {
+	return r.readBy("username", username)
+}
+
+// Update applies `$set`, `$unset`, `$inc` and `$pull` and returns the document as it was before the
+// update, like `FindOneAndUpdate` does by default.
+func (r *memUsers) Update(id string, upd map[string]interface{}) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, ok := r.docs[id]
+	if !ok {
+		return User{}, mongo.ErrNoDocuments
+	}
+	before := fromDoc(doc)
+	applyUpdate(doc, upd)
+	return before, nil
+}
+
+func applyUpdate(doc bson.M, upd map[string]interface{}) {
+	for op, fields := range upd {
+		for field, value := range fields.(bson.M) {
+			switch op {
+			case "$set":
+				doc[field] = value
+			case "$unset":
+				delete(doc, field)
+			case "$inc":
+				doc[field] = toInt64(doc[field]) + toInt64(value)
+			case "$pull":
+				var kept bson.A
+				list, _ := doc[field].(bson.A)
+				for _, v := range list {
+					if v != value {
+						kept = append(kept, v)
+					}
+				}
+				doc[field] = kept
+			default:
+				panic("unsupported update operator " + op)
+			}
+		}
+	}
+	// Round trip the document so updated values have the types they would be read back with.
+	for field, value := range toDoc(fromDoc(doc)) {
+		doc[field] = value
+	}
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	}
+	return 0
+}
+
+func (r *memUsers) IncrementFailedLogins(id string) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, ok := r.docs[id]
+	if !ok {
+		return User{}, mongo.ErrNoDocuments
+	}
+	applyUpdate(doc, bson.M{"$inc": bson.M{"failed_logins": 1}})
+	return fromDoc(doc), nil
+}
+
+func (r *memUsers) UpdatePassword(id string, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, ok := r.docs[id]
+	if !ok {
+		return pkg.ErrUserNotFound
+	}
+	doc["password"] = hash
+	return nil
+}
+
+func (r *memUsers) AdvanceTOTPStep(id string, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, ok := r.docs[id]
+	if !ok || toInt64(doc["totp_last_step"]) >= step {
+		return false, nil
+	}
+	doc["totp_last_step"] = step
+	return true, nil
+}
+
+func (r *memUsers) UseRecoveryCode(id string, hash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, ok := r.docs[id]
+	if !ok {
+		return false, nil
+	}
+	list, _ := doc["recovery_codes"].(bson.A)
+	for _, v := range list {
+		if v == hash {
+			applyUpdate(doc, bson.M{"$pull": bson.M{"recovery_codes": hash}})
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memUsers) Delete(id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.docs[id]
+	delete(r.docs, id)
+	return ok, nil
+}
+
+// memTokens is an in-memory TokenRepository.
+type memTokens struct {
+	mu     sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+func (r *memTokens) Create(rt RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens[rt.ID] = rt
+	return nil
+}
+
+func (r *memTokens) MarkUsed(id string) (RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rt, ok := r.tokens[id]
+	if !ok {
+		return RefreshToken{}, mongo.ErrNoDocuments
+	}
+	used := rt
+	used.Used = true
+	r.tokens[id] = used
+	return rt, nil
+}
+
+func (r *memTokens) RevokeFamily(familyID string) error {
+	return r.revokeWhere(func(rt RefreshToken) bool { return rt.FamilyID == familyID })
+}
+
+func (r *memTokens) RevokeUser(userID string) error {
+	return r.revokeWhere(func(rt RefreshToken) bool { return rt.UserID == userID })
+}
+
+func (r *memTokens) revokeWhere(match func(RefreshToken) bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, rt := range r.tokens {
+		if match(rt) {
+			rt.Revoked = true
+			r.tokens[id] = rt
+		}
+	}
+	return nil
+}
+
+func (r *memTokens) EnsureIndexes() error {
+	return nil
+}
+
+// memRevocations is an in-memory RevocationRepository.
+type memRevocations struct {
+	mu   sync.Mutex
+	docs map[string]RevokedToken
+}
+
+func (r *memRevocations) Revoke(jti string, userID string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.docs[jti] = RevokedToken{ID: jti, UserID: userID, ExpiresAt: expiresAt}
+	return nil
+}
+
+func (r *memRevocations) RevokeOnce(jti string, userID string, expiresAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.docs[jti]; ok {
+		return false, nil
+	}
+	r.docs[jti] = RevokedToken{ID: jti, UserID: userID, ExpiresAt: expiresAt}
+	return true, nil
+}
+
+func (r *memRevocations) RevokeUser(userID string, notBefore time.Time, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := userRevocationID(userID)
+	r.docs[id] = RevokedToken{ID: id, UserID: userID, NotBefore: notBefore, ExpiresAt: expiresAt}
+	return nil
+}
+
+func (r *memRevocations) RevokeFamily(familyID string, userID string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := familyRevocationID(familyID)
+	r.docs[id] = RevokedToken{ID: id, UserID: userID, ExpiresAt: expiresAt}
+	return nil
+}
+
+func (r *memRevocations) IsRevoked(jti string, userID string, familyID string, issuedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.docs[jti]; ok {
+		return true, nil
+	}
+	if familyID != "" {
+		if _, ok := r.docs[familyRevocationID(familyID)]; ok {
+			return true, nil
+		}
+	}
+	doc, ok := r.docs[userRevocationID(userID)]
+	return ok && issuedAt.Before(doc.NotBefore), nil
+}
+
+func (r *memRevocations) EnsureIndexes() error {
+	return nil
+}
+
+// memSessions is an in-memory SessionRepository.
+type memSessions struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+func (r *memSessions) Create(sess Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[sess.ID] = sess
+	return nil
+}
+
+func (r *memSessions) Read(id string) (Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sess, ok := r.sessions[id]
+	if !ok {
+		return Session{}, mongo.ErrNoDocuments
+	}
+	return sess, nil
+}
+
+func (r *memSessions) ListByUser(userID string) ([]Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := []Session{}
+	for _, sess := range r.sessions {
+		if sess.UserID == userID {
+			list = append(list, sess)
+		}
+	}
+	return list, nil
+}
+
+func (r *memSessions) Touch(id string, device Device, at time.Time, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sess, ok := r.sessions[id]
+	if !ok {
+		return nil
+	}
+	sess.UserAgent, sess.IP, sess.LastSeenAt, sess.ExpiresAt = device.UserAgent, device.IP, at, expiresAt
+	if device.Name != "" {
+		sess.DeviceName = device.Name
+	}
+	r.sessions[id] = sess
+	return nil
+}
+
+func (r *memSessions) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, id)
+	return nil
+}
+
+func (r *memSessions) DeleteUser(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, sess := range r.sessions {
+		if sess.UserID == userID {
+			delete(r.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (r *memSessions) EnsureIndexes() error {
+	return nil
+}
+
+// memAudit is an in-memory AuditRepository.
+type memAudit struct {
+	AuditRepository
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+func (r *memAudit) Create(entry AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+// memMagicLinks is an in-memory MagicLinkRepository.
+type memMagicLinks struct {
+	mu    sync.Mutex
+	links map[string]MagicLink
+}
+
+func (r *memMagicLinks) Create(link MagicLink) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.links[link.Hash] = link
+	return nil
+}
+
+func (r *memMagicLinks) Consume(hash string, now time.Time) (MagicLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	link, ok := r.links[hash]
+	if !ok || !link.UsedAt.IsZero() || !link.ExpiresAt.After(now) {
+		return MagicLink{}, mongo.ErrNoDocuments
+	}
+	link.UsedAt = now
+	r.links[hash] = link
+	return link, nil
+}
+
+func (r *memMagicLinks) DeleteUnused(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for hash, link := range r.links {
+		if link.UserID == userID && link.UsedAt.IsZero() {
+			delete(r.links, hash)
+		}
+	}
+	return nil
+}
+
+func (r *memMagicLinks) EnsureIndexes() error {
+	return nil
+}
+
+// memOIDC is an in-memory OIDCRepository.
+type memOIDC struct {
+	mu         sync.Mutex
+	states     map[string]OIDCState
+	identities map[string]OIDCIdentity
+}
+
+func (r *memOIDC) CreateState(st OIDCState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states[st.ID] = st
+	return nil
+}
+
+func (r *memOIDC) ConsumeState(id string) (OIDCState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st, ok := r.states[id]
+	delete(r.states, id)
+	if !ok || !st.ExpiresAt.After(time.Now()) {
+		return OIDCState{}, mongo.ErrNoDocuments
+	}
+	return st, nil
+}
+
+func (r *memOIDC) ReadIdentity(provider string, subject string) (OIDCIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id, ok := r.identities[identityID(provider, subject)]
+	if !ok {
+		return OIDCIdentity{}, mongo.ErrNoDocuments
+	}
+	return id, nil
+}
+
+func (r *memOIDC) CreateIdentity(id OIDCIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id.ID = identityID(id.Provider, id.Subject)
+	r.identities[id.ID] = id
+	return nil
+}
+
+func (r *memOIDC) DeleteUserIdentities(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, id := range r.identities {
+		if id.UserID == userID {
+			delete(r.identities, key)
+		}
+	}
+	return nil
+}
+
+func (r *memOIDC) EnsureIndexes() error {
+	return nil
+}
+
+// testEnv holds a service wired to in-memory repositories together with the fakes, so tests can
+// inspect what the service stored and sent.
+type testEnv struct {
+	svc         *Svc
+	users       *memUsers
+	tokens      *memTokens
+	revocations *memRevocations
+	sessions    *memSessions
+	oidc        *memOIDC
+	audit       *memAudit
+	magicLinks  *memMagicLinks
+	otp         *otp.FakeProvider
+	mailer      *mail.CaptureMailer
+}
+
+func testConfig() configuration.Config {
+	return configuration.Config{
+		JwtSecret:             "test-secret",
+		AccessTokenTTL:        15 * time.Minute,
+		RefreshTokenTTL:       24 * time.Hour,
+		OTPTTL:                10 * time.Minute,
+		OTPMaxAttempts:        3,
+		LockoutThreshold:      3,
+		LockoutBaseDuration:   time.Minute,
+		LockoutMaxDuration:    time.Hour,
+		PasswordResetTTL:      10 * time.Minute,
+		PasswordMinLength:     8,
+		PasswordMaxLength:     72,
+		PasswordHashAlgorithm: password.AlgorithmBcrypt,
+		BcryptCost:            4,
+		EmailVerificationTTL:  time.Hour,
+		MagicLinkTTL:          15 * time.Minute,
+		MagicLinkURL:          "https://app.example/magic-link",
+		MFAChallengeTTL:       5 * time.Minute,
+		TOTPIssuer:            "Sharir",
+		WebAuthnRPID:          "localhost",
+		WebAuthnRPName:        "Sharir",
+		WebAuthnOrigins:       []string{"http://localhost:3000"},
+		WebAuthnTimeout:       5 * time.Minute,
+		PhoneDefaultRegion:    "IN",
+	}
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	return newTestEnvWithConfig(t, testConfig())
+}
+
+func newTestEnvWithConfig(t *testing.T, config configuration.Config) *testEnv {
+	t.Helper()
+	policy, err := password.NewPolicy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher, err := password.NewHasher(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &testEnv{
+		users:       newMemUsers(),
+		tokens:      &memTokens{tokens: map[string]RefreshToken{}},
+		revocations: &memRevocations{docs: map[string]RevokedToken{}},
+		sessions:    &memSessions{sessions: map[string]Session{}},
+		oidc:        &memOIDC{states: map[string]OIDCState{}, identities: map[string]OIDCIdentity{}},
+		audit:       &memAudit{},
+		magicLinks:  &memMagicLinks{links: map[string]MagicLink{}},
+		otp:         otp.NewFakeProvider(config.OTPTTL, config.OTPMaxAttempts),
+		mailer:      mail.NewCaptureMailer(),
+	}
+	env.svc = NewAuthService(env.users, env.tokens, env.revocations, env.sessions, nil, env.oidc, env.audit, nil,
+		env.magicLinks, env.otp, policy, hasher, env.mailer, config).(*Svc)
+	return env
+}
+
+// addUser stores a user with the given password, which may be empty.
+func (env *testEnv) addUser(t *testing.T, user User, plain string) User {
+	t.Helper()
+	if user.ID == "" {
+		user.ID = primitive.NewObjectID().Hex()
+	}
+	if user.UserType == "" {
+		user.UserType = RoleClient
+	}
+	if plain != "" {
+		hash, err := env.svc.hasher.Hash(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.Password = hash
+	}
+	user, err := env.users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// user returns the stored state of the user.
+func (env *testEnv) user(t *testing.T, id string) User {
+	t.Helper()
+	user, err := env.users.Read(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// accessClaims verifies an access token the service issued and returns its claims.
+func (env *testEnv) accessClaims(t *testing.T, token string) AccessClaims {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(env.svc.config.JwtSecret), nil
+	}); err != nil {
+		t.Fatalf("invalid access token: %v", err)
+	}
+	return ClaimsFromMap(claims)
+}
+
+// sendCode sends an OTP to the phone number through the fake provider and returns the code.
+func (env *testEnv) sendCode(t *testing.T, phone string) string {
+	t.Helper()
+	if err := env.otp.SendOTP(phone); err != nil {
+		t.Fatal(err)
+	}
+	code, ok := env.otp.Code(phone)
+	if !ok {
+		t.Fatal("no code sent")
+	}
+	return code
+}
+
+// wrongCode returns a code that differs from `code`.
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"sharir/pkg"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// The purposes a grant can be issued for. A grant is a short-lived JWT that allows exactly one follow
+// up step of a flow, such as setting a new password after an OTP was verified. Grants carry a
+// `purpose` claim, which access tokens never do, so one can never be used in place of the other.
+const (
+	PurposePasswordReset       = "password_reset"
+	PurposeEmailVerification   = "email_verification"
+	PurposeMFA                 = "mfa"
+	PurposePasskeyRegistration = "passkey_registration"
+	PurposePasskeyLogin        = "passkey_login"
+	PurposeMagicLink           = "magic_link"
+)
+
+// The function signs a grant for the given user and purpose that expires after `ttl`. Every grant has
+// a unique `jti` so it can be consumed once. `extra` holds additional claims and may be nil.
+func (s *Svc) signGrant(userID string, purpose string, ttl time.Duration, extra jwt.MapClaims) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	claims["userid"] = userID
+	claims["purpose"] = purpose
+	claims["jti"] = uuid.New().String()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(s.config.JwtSecret))
+}
+
+// The function verifies a grant and checks that it was issued for the given purpose. It returns
+// `pkg.ErrInvalidGrant` for a grant that is malformed, expired, signed with another key or issued for
+// a different purpose. Every grant except a passkey login grant, which is issued before the user is
+// known, has to name a user.
+func (s *Svc) parseGrant(token string, purpose string) (AccessClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(s.config.JwtSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
+	if err != nil {
+		return AccessClaims{}, pkg.ErrInvalidGrant
+	}
+	parsed := ClaimsFromMap(claims)
+	if parsed.Purpose != purpose || parsed.ID == "" || (parsed.UserID == "" && purpose != PurposePasskeyLogin) {
+		return AccessClaims{}, pkg.ErrInvalidGrant
+	}
+	return parsed, nil
+}
+
+// The function verifies a grant for the given purpose and marks it as used. A grant that has already
+// been used returns `pkg.ErrInvalidGrant`.
+func (s *Svc) consumeGrant(token string, purpose string) (AccessClaims, error) {
+	claims, err := s.parseGrant(token, purpose)
+	if err != nil {
+		return AccessClaims{}, err
+	}
+	first, err := s.revocations.RevokeOnce(claims.ID, claims.UserID, claims.ExpiresAt)
+	if err != nil {
+		return AccessClaims{}, err
+	}
+	if !first {
+		return AccessClaims{}, pkg.ErrInvalidGrant
+	}
+	return claims, nil
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"sharir/pkg"
+	"sharir/pkg/phone"
+	"strings"
+)
+
+// The kinds of identifier a user can log in with.
+const (
+	IdentifierPhone    = "phone"
+	IdentifierEmail    = "email"
+	IdentifierUsername = "username"
+)
+
+// The Identifier type is a login identifier after its kind has been detected and it has been
+// normalized.
+// @property {string} Kind - One of `IdentifierPhone`, `IdentifierEmail` or `IdentifierUsername`.
+// @property {string} Value - The identifier in the form it is stored in: phone numbers in E.164,
+// email addresses lower cased and usernames trimmed.
+// @property {string} Raw - The identifier as it was given, trimmed. Usernames may look like a phone
+// number or contain an `@`, so phone numbers and email addresses no user has are looked up as a
+// username with it.
+type Identifier struct {
+	Kind  string
+	Value string
+	Raw   string
+}
+
+// The function detects the kind of a login identifier and normalizes it. Anything with an `@` is an
+// email address, anything made only of digits and the characters phone numbers are written with is a
+// phone number read in `region` when it has no country code, and everything else, including what
+// looks like a phone number but cannot be read as one, is a username. It reports false for an empty
+// identifier.
+func ParseIdentifier(raw string, region string) (Identifier, bool) {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case raw == "":
+		return Identifier{}, false
+	case strings.Contains(raw, "@"):
+		return Identifier{Kind: IdentifierEmail, Value: strings.ToLower(raw), Raw: raw}, true
+	case looksLikePhone(raw):
+		if normalized, err := phone.Normalize(raw, region); err == nil {
+			return Identifier{Kind: IdentifierPhone, Value: normalized, Raw: raw}, true
+		}
+	}
+	return Identifier{Kind: IdentifierUsername, Value: raw, Raw: raw}, true
+}
+
+// The function reports whether `s` is written like a phone number: digits, optionally led by a plus
+// sign and separated by spaces, dashes, dots or parentheses.
+func looksLikePhone(s string) bool {
+	digits := 0
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return false
+		}
+	}
+	return digits > 0
+}
+
+// The function returns the user an identifier belongs to, looked up through the repository method of
+// its kind. A phone number or email address no user has is then looked up as a username, so that
+// users whose username is made of digits or contains an `@` can still log in with it. It returns
+// `pkg.ErrUserNotFound` if no user has it.
+func ReadByIdentifier(repo Repository, id Identifier) (User, error) {
+	var user User
+	var err error
+	switch id.Kind {
+	case IdentifierPhone:
+		user, err = repo.ReadByPhoneNumber(id.Value)
+	case IdentifierEmail:
+		user, err = repo.ReadByEmail(id.Value)
+	default:
+		return repo.ReadByUsernanme(id.Value)
+	}
+	if err == pkg.ErrUserNotFound && id.Raw != "" {
+		return repo.ReadByUsernanme(id.Raw)
+	}
+	return user, err
+}
+
+// The function returns the user an identifier belongs to, see `ReadByIdentifier`.
+func (s *Svc) readByIdentifier(id Identifier) (User, error) {
+	return ReadByIdentifier(s.repo, id)
+}
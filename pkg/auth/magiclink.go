@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sharir/pkg"
+	"sharir/pkg/mail"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The `SendMagicLink` function emails a magic login link to the user with the given email address. The
+// link carries a signed single-use grant that expires after `MagicLinkTTL`; only its hash is stored,
+// and requesting a new link invalidates the unused ones. Links are only sent to verified addresses:
+// anyone can sign up with an address they do not own, and a link to it would log its owner into an
+// account whose password someone else knows. To not reveal which addresses have an account, nothing
+// is sent to unknown, unverified or suspended accounts and the function returns nil either way.
+// Failing to send the email is logged for the same reason.
+func (s *Svc) SendMagicLink(email string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !strings.Contains(email, "@") {
+		return nil
+	}
+	user, err := s.repo.ReadByEmail(email)
+	if err == pkg.ErrUserNotFound || (err == nil && (user.Suspended || !user.EmailVerified)) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.magicLinks.DeleteUnused(user.ID); err != nil {
+		return err
+	}
+	token, err := s.signGrant(user.ID, PurposeMagicLink, s.config.MagicLinkTTL, jwt.MapClaims{"email": user.Email})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	link := MagicLink{
+		Hash:      hashToken(token),
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: now.Add(s.config.MagicLinkTTL),
+		CreatedAt: now,
+	}
+	if err := s.magicLinks.Create(link); err != nil {
+		return err
+	}
+	target := fmt.Sprintf("%s?token=%s", s.config.MagicLinkURL, url.QueryEscape(token))
+	err = s.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body:    fmt.Sprintf("Hi %s,\n\nOpen this link to log in:\n\n%s\n\nThe link expires in %s and can be used once. If you did not ask for it, you can ignore this email.\n", user.Name, target, s.config.MagicLinkTTL),
+	})
+	if err != nil {
+		log.Printf("auth: sending magic link to user %s: %v", user.ID, err)
+	}
+	return nil
+}
+
+// The `RedeemMagicLink` function logs a user in with the token of a magic link and issues the same
+// tokens as `Login`; users with TOTP enabled get a `*pkg.MFARequiredError` instead. The token has to
+// carry a valid signature and match an unused, unexpired stored link, which is marked as used. It is
+// rejected if the user's email is no longer the verified address it was sent to.
+func (s *Svc) RedeemMagicLink(token string, device Device) (TokenPair, error) {
+	claims, err := s.parseGrant(token, PurposeMagicLink)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	link, err := s.magicLinks.Consume(hashToken(token), time.Now())
+	if err == mongo.ErrNoDocuments {
+		return TokenPair{}, pkg.ErrInvalidGrant
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+	user, err := s.repo.Read(link.UserID)
+	if err != nil || link.UserID != claims.UserID || user.Email == "" || !strings.EqualFold(user.Email, link.Email) ||
+		!user.EmailVerified {
+		return TokenPair{}, pkg.ErrInvalidGrant
+	}
+	if user.TOTPEnabled {
+		return TokenPair{}, s.mfaChallenge(user)
+	}
+	return s.startSession(user, device)
+}
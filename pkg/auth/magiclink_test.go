@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"net/url"
+	"regexp"
+	"sharir/pkg"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var magicLinkToken = regexp.MustCompile(`token=(\S+)`)
+
+// magicLink requests a magic link for the address and returns the token of the emailed link, or ""
+// if nothing was sent.
+func (env *testEnv) magicLink(t *testing.T, email string) string {
+	t.Helper()
+	sent := len(env.mailer.Messages())
+	if err := env.svc.SendMagicLink(email); err != nil {
+		t.Fatal(err)
+	}
+	messages := env.mailer.Messages()
+	if len(messages) == sent {
+		return ""
+	}
+	match := magicLinkToken.FindStringSubmatch(messages[len(messages)-1].Body)
+	if match == nil {
+		t.Fatalf("no link in %q", messages[len(messages)-1].Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestSendMagicLink(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser(t, User{Name: "Asha", Email: "asha@example.com", EmailVerified: true}, "")
+	env.addUser(t, User{Name: "Ravi", Email: "ravi@example.com"}, "ravi-pass-3318")
+	env.addUser(t, User{Name: "Meera", Email: "meera@example.com", EmailVerified: true, Suspended: true}, "")
+
+	if token := env.magicLink(t, " Asha@Example.com "); token == "" {
+		t.Error("no link sent to a verified address")
+	}
+	if msg, ok := env.mailer.Last("asha@example.com"); !ok || msg.Subject != "Your login link" {
+		t.Errorf("link sent as %+v", msg)
+	}
+	for _, email := range []string{"ravi@example.com", "meera@example.com", "nobody@example.com", "not-an-email"} {
+		if token := env.magicLink(t, email); token != "" {
+			t.Errorf("link sent to %s", email)
+		}
+	}
+}
+
+func TestRedeemMagicLink(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.addUser(t, User{Name: "Asha", Email: "asha@example.com", EmailVerified: true}, "")
+	token := env.magicLink(t, "asha@example.com")
+	tokens, err := env.svc.RedeemMagicLink(token, Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims := env.accessClaims(t, tokens.AccessToken); claims.UserID != user.ID {
+		t.Errorf("token issued to %q, want %q", claims.UserID, user.ID)
+	}
+	if _, err := env.svc.Refresh(tokens.RefreshToken, Device{}); err != nil {
+		t.Errorf("refresh token of the magic link login: %v", err)
+	}
+	if _, err := env.svc.RedeemMagicLink(token, Device{}); err != pkg.ErrInvalidGrant {
+		t.Errorf("second use: got %v", err)
+	}
+}
+
+func TestRedeemMagicLinkRejected(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.addUser(t, User{Name: "Asha", Email: "asha@example.com", EmailVerified: true}, "")
+
+	replaced := env.magicLink(t, "asha@example.com")
+	env.magicLink(t, "asha@example.com")
+	if _, err := env.svc.RedeemMagicLink(replaced, Device{}); err != pkg.ErrInvalidGrant {
+		t.Errorf("link replaced by a newer one: got %v", err)
+	}
+
+	expired := env.magicLink(t, "asha@example.com")
+	env.magicLinks.mu.Lock()
+	link := env.magicLinks.links[hashToken(expired)]
+	link.ExpiresAt = time.Now().Add(-time.Second)
+	env.magicLinks.links[link.Hash] = link
+	env.magicLinks.mu.Unlock()
+	if _, err := env.svc.RedeemMagicLink(expired, Device{}); err != pkg.ErrInvalidGrant {
+		t.Errorf("expired link: got %v", err)
+	}
+
+	unverified := env.magicLink(t, "asha@example.com")
+	if _, err := env.users.Update(user.ID, map[string]interface{}{"$set": bson.M{"email_verified": false}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.svc.RedeemMagicLink(unverified, Device{}); err != pkg.ErrInvalidGrant {
+		t.Errorf("address no longer verified: got %v", err)
+	}
+	if _, err := env.users.Update(user.ID, map[string]interface{}{"$set": bson.M{"email_verified": true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := env.magicLink(t, "asha@example.com")
+	if _, err := env.users.Update(user.ID, map[string]interface{}{"$set": bson.M{"email": "asha@other.example"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.svc.RedeemMagicLink(changed, Device{}); err != pkg.ErrInvalidGrant {
+		t.Errorf("email changed after sending: got %v", err)
+	}
+
+	if _, err := env.svc.RedeemMagicLink("not-a-token", Device{}); err != pkg.ErrInvalidGrant {
+		t.Errorf("malformed token: got %v", err)
+	}
+	if len(env.tokens.tokens) != 0 {
+		t.Error("tokens issued for a rejected link")
+	}
+}
+
+func TestRedeemMagicLinkRequiresMFA(t *testing.T) {
+	env := newTestEnv(t)
+	user, _, recovery := totpUser(t, env)
+	if _, err := env.users.Update(user.ID, map[string]interface{}{"$set": bson.M{"email": "ravi@example.com", "email_verified": true}}); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := env.svc.RedeemMagicLink(env.magicLink(t, "ravi@example.com"), Device{})
+	challenge := mfaToken(t, err)
+	if tokens != (TokenPair{}) || len(env.tokens.tokens) != 0 {
+		t.Fatal("tokens issued before the second factor")
+	}
+	if tokens, err = env.svc.VerifyMFA(challenge, recovery[0], Device{}); err != nil || tokens.AccessToken == "" {
+		t.Fatalf("second factor: tokens %+v, err %v", tokens, err)
+	}
+}
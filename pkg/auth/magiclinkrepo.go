@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The MagicLink type is an issued magic login link. Only the hash of the link's token is stored, so
+// the links cannot be used by someone who can read the collection.
+// @property {string} Hash - The SHA-256 hash of the token, used as the document ID.
+// @property {string} UserID - The ID of the user the link logs in.
+// @property {string} Email - The address the link was sent to.
+// @property ExpiresAt - The time the link stops working. Expired links are removed by MongoDB.
+// @property UsedAt - The time the link was redeemed; zero while it is unused.
+// @property CreatedAt - The time the link was issued.
+type MagicLink struct {
+	Hash      string    `json:"-" bson:"_id"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	Email     string    `json:"email" bson:"email"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+	UsedAt    time.Time `json:"used_at" bson:"used_at,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// MagicLinkRepository defines the operations that can be performed on stored magic login links.
+type MagicLinkRepository interface {
+	Create(link MagicLink) error
+	Consume(hash string, now time.Time) (MagicLink, error)
+	DeleteUnused(userID string) error
+	EnsureIndexes() error
+}
+
+// MagicLinkRepo is the struct that implements the MagicLinkRepository interface on top of the
+// `magic_links` collection. To create a MagicLinkRepo, use the NewMagicLinkRepo function.
+type MagicLinkRepo struct {
+	db      *mongo.Collection
+	context context.Context
+}
+
+// The function stores a newly issued magic link.
+func (s *MagicLinkRepo) Create(link MagicLink) error {
+	_, err := s.db.InsertOne(s.context, link)
+	return err
+}
+
+// The function atomically marks the unused, unexpired link with the given hash as used and returns it.
+// It returns `mongo.ErrNoDocuments` if there is no such link, which is also what a second attempt to
+// use the same link gets.
+func (s *MagicLinkRepo) Consume(hash string, now time.Time) (MagicLink, error) {
+	var link MagicLink
+	err := s.db.FindOneAndUpdate(s.context,
+		bson.M{"_id": hash, "used_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"used_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&link)
+	return link, err
+}
+
+// The function removes the unused links of the user, so that only the newest link works.
+func (s *MagicLinkRepo) DeleteUnused(userID string) error {
+	_, err := s.db.DeleteMany(s.context, bson.M{"user_id": userID, "used_at": bson.M{"$exists": false}})
+	return err
+}
+
+// The function creates the indexes the collection relies on: a TTL index that lets MongoDB remove
+// expired links and an index on the user ID.
+func (s *MagicLinkRepo) EnsureIndexes() error {
+	_, err := s.db.Indexes().CreateMany(s.context, []mongo.IndexModel{
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.M{"user_id": 1}},
+	})
+	return err
+}
+
+// The function returns a new instance of a MagicLinkRepository interface implementation with a
+// MongoDB database connection.
+func NewMagicLinkRepo(db *mongo.Database) MagicLinkRepository {
+	ctx := context.TODO()
+	return &MagicLinkRepo{db: db.Collection("magic_links"), context: ctx}
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"sharir/pkg"
+	"sharir/pkg/totp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// `recoveryCodeCount` is the number of recovery codes handed out when TOTP is enabled.
+const recoveryCodeCount = 10
+
+// `totpSkew` is the number of 30 second time steps a TOTP code may be off by.
+const totpSkew = 1
+
+// The `EnrollTOTP` function starts TOTP enrollment for the user. It generates a new secret, stores it
+// as pending and returns it together with the `otpauth://` URI to show as a QR code. TOTP is only
+// enabled once `ConfirmTOTP` has seen a valid code for the secret.
+func (s *Svc) EnrollTOTP(userID string) (string, string, error) {
+	user, err := s.repo.Read(userID)
+	if err != nil {
+		return "", "", pkg.ErrUserNotFound
+	}
+	if user.TOTPEnabled {
+		return "", "", pkg.ErrTOTPAlreadyEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := s.repo.Update(user.ID, map[string]interface{}{"$set": bson.M{"totp_pending_secret": secret}}); err != nil {
+		return "", "", err
+	}
+	account := user.Email
+	if account == "" {
+		account = user.PhoneNumber
+	}
+	return secret, totp.URI(s.config.TOTPIssuer, account, secret), nil
+}
+
+// The `ConfirmTOTP` function finishes TOTP enrollment. If the code is valid for the pending secret,
+// TOTP is enabled and a fresh set of recovery codes is returned. The codes are only stored hashed, so
+// this is the only time they can be shown to the user.
+func (s *Svc) ConfirmTOTP(userID string, code string) ([]string, error) {
+	user, err := s.repo.Read(userID)
+	if err != nil {
+		return nil, pkg.ErrUserNotFound
+	}
+	if user.TOTPEnabled {
+		return nil, pkg.ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPPendingSecret == "" {
+		return nil, pkg.ErrTOTPNotEnrolled
+	}
+	step, ok := totp.Validate(user.TOTPPendingSecret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, pkg.ErrInvalidMFACode
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.repo.Update(user.ID, map[string]interface{}{
+		"$set": bson.M{
+			"totp_enabled":   true,
+			"totp_secret":    user.TOTPPendingSecret,
+			"totp_last_step": step,
+			"recovery_codes": hashes,
+		},
+		"$unset": bson.M{"totp_pending_secret": ""},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// The `DisableTOTP` function turns TOTP off for the user. A valid TOTP code or an unused recovery code
+// is required.
+func (s *Svc) DisableTOTP(userID string, code string) error {
+	user, err := s.repo.Read(userID)
+	if err != nil {
+		return pkg.ErrUserNotFound
+	}
+	if !user.TOTPEnabled {
+		return pkg.ErrTOTPNotEnrolled
+	}
+	if err := s.checkSecondFactor(user, code); err != nil {
+		return err
+	}
+	_, err = s.repo.Update(user.ID, map[string]interface{}{
+		"$set":   bson.M{"totp_enabled": false},
+		"$unset": bson.M{"totp_secret": "", "totp_last_step": "", "recovery_codes": ""},
+	})
+	return err
+}
+
+// The `VerifyMFA` function completes a login that `Login` answered with a `*pkg.MFARequiredError`. It
+// takes the challenge token from that error and a TOTP code or recovery code. Wrong codes count as
+// failed logins, so the lockout also protects the second factor. The challenge can only be completed
+// once.
+func (s *Svc) VerifyMFA(mfaToken string, code string, device Device) (TokenPair, error) {
+	claims, err := s.parseGrant(mfaToken, PurposeMFA)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	user, err := s.repo.Read(claims.UserID)
+	if err != nil {
+		return TokenPair{}, pkg.ErrInvalidGrant
+	}
+	if time.Now().Before(user.LockedUntil) {
+		return TokenPair{}, &pkg.AccountLockedError{Until: user.LockedUntil}
+	}
+	if err := s.checkSecondFactor(user, code); err != nil {
+		if lockErr := s.recordFailedLogin(user.ID); lockErr != nil {
+			return TokenPair{}, lockErr
+		}
+		return TokenPair{}, err
+	}
+	if _, err := s.consumeGrant(mfaToken, PurposeMFA); err != nil {
+		return TokenPair{}, err
+	}
+	if user.FailedLogins > 0 {
+		if err := s.resetFailedLogins(user.ID); err != nil {
+			return TokenPair{}, err
+		}
+	}
+	return s.startSession(user, device)
+}
+
+// The function issues the challenge that `Login` returns instead of tokens when the user has TOTP
+// enabled. Suspended users are turned away before they are asked for a code.
+func (s *Svc) mfaChallenge(user User) error {
+	if user.Suspended {
+		return pkg.ErrAccountSuspended
+	}
+	token, err := s.signGrant(user.ID, PurposeMFA, s.config.MFAChallengeTTL, nil)
+	if err != nil {
+		return err
+	}
+	return &pkg.MFARequiredError{Token: token}
+}
+
+// The function checks a second factor for the user. `code` is either a TOTP code, which must be from a
+// later time step than the last accepted one so it cannot be replayed, or a recovery code, which is
+// removed once it has been used. Both are claimed with a conditional update, so a code that is
+// presented by two concurrent logins is only accepted once.
+func (s *Svc) checkSecondFactor(user User, code string) error {
+	code = strings.TrimSpace(code)
+	accepted := false
+	var err error
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), totpSkew); ok {
+		accepted, err = s.repo.AdvanceTOTPStep(user.ID, step)
+	} else {
+		accepted, err = s.repo.UseRecoveryCode(user.ID, hashToken(normalizeRecoveryCode(code)))
+	}
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return pkg.ErrInvalidMFACode
+	}
+	return nil
+}
+
+// The function returns a new set of recovery codes formatted as "xxxxx-xxxxx" together with their
+// hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(enc.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashToken(raw)
+	}
+	return codes, hashes, nil
+}
+
+// The function strips the formatting of a recovery code so that it matches however the user typed it.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
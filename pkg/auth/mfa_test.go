@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"errors"
+	"sharir/pkg"
+	"sharir/pkg/totp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// totpUser stores a user with the phone number and TOTP enabled and returns it with its secret and
+// recovery codes.
+func totpUser(t *testing.T, env *testEnv) (User, string, []string) {
+	t.Helper()
+	user := env.addUser(t, User{Name: "Ravi", PhoneNumber: testPhone, PhoneVerified: true}, "ravi-pass-3318")
+	secret, _, err := env.svc.EnrollTOTP(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The enrollment code is from the previous time step, so the current code is still unused.
+	code, err := totp.Code(secret, time.Now().Add(-totp.Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovery, err := env.svc.ConfirmTOTP(user.ID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env.user(t, user.ID), secret, recovery
+}
+
+func mfaToken(t *testing.T, err error) string {
+	t.Helper()
+	var mfa *pkg.MFARequiredError
+	if !errors.As(err, &mfa) {
+		t.Fatalf("got %v, want an MFA challenge", err)
+	}
+	return mfa.Token
+}
+
+func TestLoginPhoneOtpRequiresMFA(t *testing.T) {
+	env := newTestEnv(t)
+	_, secret, _ := totpUser(t, env)
+	tokens, _, err := env.svc.LoginPhoneOtp(testPhone, env.sendCode(t, testPhone), Device{})
+	challenge := mfaToken(t, err)
+	if tokens != (TokenPair{}) || len(env.tokens.tokens) != 0 {
+		t.Fatal("tokens issued before the second factor")
+	}
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens, err = env.svc.VerifyMFA(challenge, code, Device{}); err != nil || tokens.AccessToken == "" {
+		t.Fatalf("second factor: tokens %+v, err %v", tokens, err)
+	}
+}
+
+func TestTOTPCodeAcceptedOnce(t *testing.T) {
+	env := newTestEnv(t)
+	user, secret, _ := totpUser(t, env)
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := concurrently(2, func() error { return env.svc.checkSecondFactor(user, code) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.svc.checkSecondFactor(user, code); err != pkg.ErrInvalidMFACode {
+		t.Errorf("replayed code: got %v", err)
+	}
+}
+
+func TestRecoveryCodeAcceptedOnce(t *testing.T) {
+	env := newTestEnv(t)
+	user, _, recovery := totpUser(t, env)
+	if err := concurrently(2, func() error { return env.svc.checkSecondFactor(user, recovery[0]) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.svc.checkSecondFactor(user, recovery[0]); err != pkg.ErrInvalidMFACode {
+		t.Errorf("used recovery code: got %v", err)
+	}
+	if got := env.user(t, user.ID).RecoveryCodes; len(got) != len(recovery)-1 {
+		t.Errorf("%d recovery codes left, want %d", len(got), len(recovery)-1)
+	}
+	if err := env.svc.checkSecondFactor(user, recovery[1]); err != nil {
+		t.Errorf("unused recovery code: %v", err)
+	}
+}
+
+// concurrently runs `n` copies of `f` at once with the same user as read before any of them ran, and
+// returns an error unless exactly one succeeded and the others were rejected as invalid codes.
+func concurrently(n int, f func() error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = f()
+		}(i)
+	}
+	wg.Wait()
+	accepted := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			accepted++
+		case pkg.ErrInvalidMFACode:
+		default:
+			return err
+		}
+	}
+	if accepted != 1 {
+		return errors.New("code not accepted exactly once")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The OIDCState type is the document stored in the `oidc_states` collection while a social login is
+// in progress. It keeps the PKCE verifier and nonce on the server, so only the state value passes
+// through the browser.
+// @property {string} ID - The SHA-256 hash of the state parameter.
+// @property {string} Provider - The name of the provider the login was started with.
+// @property {string} Verifier - The PKCE code verifier.
+// @property {string} Nonce - The nonce the ID token has to carry.
+// @property ExpiresAt - The time after which the login can no longer be completed. MongoDB removes the
+// document through a TTL index once this time has passed.
+type OIDCState struct {
+	ID        string    `bson:"_id"`
+	Provider  string    `bson:"provider"`
+	Verifier  string    `bson:"verifier"`
+	Nonce     string    `bson:"nonce"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// The OIDCIdentity type is the document stored in the `oidc_identities` collection for every provider
+// account that is linked to a user.
+// @property {string} ID - The provider name and the subject of the provider account, joined by a colon.
+// @property {string} UserID - The ID of the linked user.
+// @property {string} Provider - The provider name.
+// @property {string} Subject - The `sub` claim of the provider account.
+// @property CreatedAt - The time the account was linked.
+type OIDCIdentity struct {
+	ID        string    `bson:"_id"`
+	UserID    string    `bson:"user_id"`
+	Provider  string    `bson:"provider"`
+	Subject   string    `bson:"subject"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// OIDCRepository defines the operations on social login state and linked provider accounts.
+type OIDCRepository interface {
+	CreateState(st OIDCState) error
+	ConsumeState(id string) (OIDCState, error)
+	ReadIdentity(provider string, subject string) (OIDCIdentity, error)
+	CreateIdentity(id OIDCIdentity) error
+	DeleteUserIdentities(userID string) error
+	EnsureIndexes() error
+}
+
+// OIDCRepo is the struct that implements the OIDCRepository interface on top of the `oidc_states` and
+// `oidc_identities` collections. To create an OIDCRepo, use the NewOIDCRepo function.
+type OIDCRepo struct {
+	states     *mongo.Collection
+	identities *mongo.Collection
+	context    context.Context
+}
+
+// The function stores the state of a social login that has been started.
+func (s *OIDCRepo) CreateState(st OIDCState) error {
+	_, err := s.states.InsertOne(s.context, st)
+	return err
+}
+
+// The function removes and returns the state with the given hash, so every state can be used once.
+// Expired states are not returned even if MongoDB has not removed them yet.
+func (s *OIDCRepo) ConsumeState(id string) (OIDCState, error) {
+	var st OIDCState
+	err := s.states.FindOneAndDelete(s.context, bson.M{"_id": id, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&st)
+	return st, err
+}
+
+// The function returns the linked identity of the given provider account.
+func (s *OIDCRepo) ReadIdentity(provider string, subject string) (OIDCIdentity, error) {
+	var id OIDCIdentity
+	err := s.identities.FindOne(s.context, bson.M{"_id": identityID(provider, subject)}).Decode(&id)
+	return id, err
+}
+
+// The function links a provider account to a user.
+func (s *OIDCRepo) CreateIdentity(id OIDCIdentity) error {
+	id.ID = identityID(id.Provider, id.Subject)
+	_, err := s.identities.InsertOne(s.context, id)
+	return err
+}
+
+// The function unlinks every provider account of the given user.
+func (s *OIDCRepo) DeleteUserIdentities(userID string) error {
+	_, err := s.identities.DeleteMany(s.context, bson.M{"user_id": userID})
+	return err
+}
+
+// The function creates the TTL index that removes abandoned logins and the index on the user ID of
+// linked identities.
+func (s *OIDCRepo) EnsureIndexes() error {
+	if _, err := s.states.Indexes().CreateOne(s.context, mongo.IndexModel{
+		Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0),
+	}); err != nil {
+		return err
+	}
+	_, err := s.identities.Indexes().CreateOne(s.context, mongo.IndexModel{Keys: bson.M{"user_id": 1}})
+	return err
+}
+
+// The function returns the document ID of a provider account.
+func identityID(provider string, subject string) string {
+	return provider + ":" + subject
+}
+
+// The function returns a new instance of an OIDCRepository interface implementation with a MongoDB
+// database connection.
+func NewOIDCRepo(db *mongo.Database) OIDCRepository {
+	ctx := context.TODO()
+	return &OIDCRepo{states: db.Collection("oidc_states"), identities: db.Collection("oidc_identities"), context: ctx}
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"fmt"
+	"sharir/pkg"
+	"sharir/pkg/webauthn"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The `BeginPasskeyRegistration` function starts registering a passkey for the logged in user. It
+// returns the options to pass to `navigator.credentials.create` and a short-lived session token that
+// carries the challenge and has to be sent back with the result.
+func (s *Svc) BeginPasskeyRegistration(userID string) (webauthn.CreationOptions, string, error) {
+	user, err := s.repo.Read(userID)
+	if err != nil {
+		return webauthn.CreationOptions{}, "", pkg.ErrUserNotFound
+	}
+	existing, err := s.passkeys.ListByUser(user.ID)
+	if err != nil {
+		return webauthn.CreationOptions{}, "", err
+	}
+	exclude := make([][]byte, 0, len(existing))
+	for _, p := range existing {
+		if id, err := webauthn.Decode(p.ID); err == nil {
+			exclude = append(exclude, id)
+		}
+	}
+	challenge, session, err := s.passkeyChallenge(user.ID, PurposePasskeyRegistration)
+	if err != nil {
+		return webauthn.CreationOptions{}, "", err
+	}
+	name := user.Username
+	if name == "" {
+		name = user.PhoneNumber
+	}
+	return s.webauthn.CreationOptions(challenge, []byte(user.ID), name, user.Name, exclude), session, nil
+}
+
+// The `FinishPasskeyRegistration` function verifies the result of a registration ceremony started by
+// `BeginPasskeyRegistration` and stores the new passkey under the given name. A response whose
+// credential ID differs from the one in the authenticator data is rejected, so the stored ID is always
+// the one the authenticator will present at login.
+func (s *Svc) FinishPasskeyRegistration(userID string, session string, name string, resp webauthn.AttestationResponse) (Passkey, error) {
+	claims, err := s.consumeGrant(session, PurposePasskeyRegistration)
+	if err != nil {
+		return Passkey{}, err
+	}
+	if claims.UserID != userID {
+		return Passkey{}, pkg.ErrInvalidGrant
+	}
+	challenge, err := webauthn.Decode(claims.Challenge)
+	if err != nil {
+		return Passkey{}, pkg.ErrInvalidGrant
+	}
+	cred, err := s.webauthn.VerifyRegistration(challenge, resp)
+	if err != nil {
+		return Passkey{}, fmt.Errorf("%w: %v", pkg.ErrInvalidPasskey, err)
+	}
+	passkey := Passkey{
+		ID:        webauthn.Encode(cred.ID),
+		UserID:    userID,
+		Name:      name,
+		PublicKey: cred.PublicKey,
+		SignCount: cred.SignCount,
+		CreatedAt: time.Now(),
+	}
+	if err := s.passkeys.Create(passkey); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return Passkey{}, pkg.ErrPasskeyExists
+		}
+		return Passkey{}, err
+	}
+	return passkey, nil
+}
+
+// The `BeginPasskeyLogin` function starts a passkey login. No user name is needed: the browser offers
+// the passkeys it holds for this service and the chosen one identifies the user.
+func (s *Svc) BeginPasskeyLogin() (webauthn.RequestOptions, string, error) {
+	challenge, session, err := s.passkeyChallenge("", PurposePasskeyLogin)
+	if err != nil {
+		return webauthn.RequestOptions{}, "", err
+	}
+	return s.webauthn.RequestOptions(challenge, nil), session, nil
+}
+
+// The `FinishPasskeyLogin` function verifies the result of a login ceremony started by
+// `BeginPasskeyLogin` and issues the same tokens as a password login. Passkeys require user
+// verification on the authenticator, so no second factor is asked for.
+func (s *Svc) FinishPasskeyLogin(session string, resp webauthn.AssertionResponse, device Device) (TokenPair, error) {
+	claims, err := s.consumeGrant(session, PurposePasskeyLogin)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	challenge, err := webauthn.Decode(claims.Challenge)
+	if err != nil {
+		return TokenPair{}, pkg.ErrInvalidGrant
+	}
+	passkey, err := s.passkeys.Read(resp.ID)
+	if err != nil {
+		return TokenPair{}, pkg.ErrInvalidPasskey
+	}
+	if resp.Response.UserHandle != "" {
+		handle, err := webauthn.Decode(resp.Response.UserHandle)
+		if err != nil || string(handle) != passkey.UserID {
+			return TokenPair{}, pkg.ErrInvalidPasskey
+		}
+	}
+	signCount, err := s.webauthn.VerifyAssertion(challenge, passkey.PublicKey, passkey.SignCount, resp)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("%w: %v", pkg.ErrInvalidPasskey, err)
+	}
+	if err := s.passkeys.RecordUse(passkey.ID, signCount, time.Now()); err != nil {
+		return TokenPair{}, err
+	}
+	user, err := s.repo.Read(passkey.UserID)
+	if err != nil {
+		return TokenPair{}, pkg.ErrInvalidPasskey
+	}
+	return s.startSession(user, device)
+}
+
+// The `ListPasskeys` function returns the passkeys registered by the user.
+func (s *Svc) ListPasskeys(userID string) ([]Passkey, error) {
+	return s.passkeys.ListByUser(userID)
+}
+
+// The `DeletePasskey` function removes one of the user's passkeys.
+func (s *Svc) DeletePasskey(userID string, id string) error {
+	deleted, err := s.passkeys.Delete(userID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return pkg.ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// The function creates the challenge of a ceremony and signs it into a grant for the given purpose.
+// Login grants are issued before the user is known and carry no user ID.
+func (s *Svc) passkeyChallenge(userID string, purpose string) ([]byte, string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, "", err
+	}
+	session, err := s.signGrant(userID, purpose, s.config.WebAuthnTimeout, jwt.MapClaims{"challenge": webauthn.Encode(challenge)})
+	if err != nil {
+		return nil, "", err
+	}
+	return challenge, session, nil
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The Passkey type is the document stored in the `webauthn_credentials` collection for every passkey a
+// user registers.
+// @property {string} ID - The base64url encoded credential ID chosen by the authenticator.
+// @property {string} UserID - The ID of the user the passkey belongs to.
+// @property {string} Name - A name the user gave the passkey, such as "iPhone".
+// @property {[]byte} PublicKey - The COSE encoded public key of the credential.
+// @property {uint32} SignCount - The last signature counter seen, used to detect cloned authenticators.
+// @property CreatedAt - The time the passkey was registered.
+// @property LastUsedAt - The time the passkey was last used to log in.
+type Passkey struct {
+	ID         string    `bson:"_id" json:"id"`
+	UserID     string    `bson:"user_id" json:"-"`
+	Name       string    `bson:"name" json:"name"`
+	PublicKey  []byte    `bson:"public_key" json:"-"`
+	SignCount  uint32    `bson:"sign_count" json:"-"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	LastUsedAt time.Time `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+}
+
+// PasskeyRepository defines the operations that can be performed on stored passkeys.
+type PasskeyRepository interface {
+	Create(p Passkey) error
+	Read(id string) (Passkey, error)
+	ListByUser(userID string) ([]Passkey, error)
+	RecordUse(id string, signCount uint32, at time.Time) error
+	Delete(userID string, id string) (bool, error)
+	DeleteUser(userID string) error
+	EnsureIndexes() error
+}
+
+// PasskeyRepo is the struct that implements the PasskeyRepository interface on top of the
+// `webauthn_credentials` collection. To create a PasskeyRepo, use the NewPasskeyRepo function.
+type PasskeyRepo struct {
+	db      *mongo.Collection
+	context context.Context
+}
+
+// The function stores a newly registered passkey. Registering a credential ID that is already stored
+// fails with a duplicate key error.
+func (s *PasskeyRepo) Create(p Passkey) error {
+	_, err := s.db.InsertOne(s.context, p)
+	return err
+}
+
+// The function returns the passkey with the given credential ID.
+func (s *PasskeyRepo) Read(id string) (Passkey, error) {
+	var p Passkey
+	err := s.db.FindOne(s.context, bson.M{"_id": id}).Decode(&p)
+	return p, err
+}
+
+// The function returns the passkeys of the given user, oldest first.
+func (s *PasskeyRepo) ListByUser(userID string) ([]Passkey, error) {
+	cur, err := s.db.Find(s.context, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	passkeys := []Passkey{}
+	if err := cur.All(s.context, &passkeys); err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+// The function stores the signature counter of a successful login and the time it happened.
+func (s *PasskeyRepo) RecordUse(id string, signCount uint32, at time.Time) error {
+	_, err := s.db.UpdateOne(s.context, bson.M{"_id": id}, bson.M{"$set": bson.M{"sign_count": signCount, "last_used_at": at}})
+	return err
+}
+
+// The function removes a passkey of the given user. It reports whether a passkey was removed.
+func (s *PasskeyRepo) Delete(userID string, id string) (bool, error) {
+	res, err := s.db.DeleteOne(s.context, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+// The function removes every passkey of the given user.
+func (s *PasskeyRepo) DeleteUser(userID string) error {
+	_, err := s.db.DeleteMany(s.context, bson.M{"user_id": userID})
+	return err
+}
+
+// The function creates the index on the user ID that listing a user's passkeys relies on.
+func (s *PasskeyRepo) EnsureIndexes() error {
+	_, err := s.db.Indexes().CreateOne(s.context, mongo.IndexModel{Keys: bson.M{"user_id": 1}})
+	return err
+}
+
+// The function returns a new instance of a PasskeyRepository interface implementation with a MongoDB
+// database connection.
+func NewPasskeyRepo(db *mongo.Database) PasskeyRepository {
+	ctx := context.TODO()
+	return &PasskeyRepo{db: db.Collection("webauthn_credentials"), context: ctx}
+}
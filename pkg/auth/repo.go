@@ -3,11 +3,15 @@ package auth
 import (
 	"context"
 	"errors"
+	"log"
+	"regexp"
 	"sharir/pkg"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Repository is an interfaces that defines the schema of
@@ -16,13 +20,23 @@ import (
 // case we migrate away from gorm.
 type Repository interface {
 	Create(in InUser) (User, error)
+	Insert(user User) (User, error)
 	Read(id string) (User, error)
 	Update(id string, upd map[string]interface{}) (User, error)
-	Delete(string int) bool
+	Delete(id string) (bool, error)
 	ReadByID(id string) (User, error)
 	ReadByEmail(email string) (User, error)
 	ReadByPhoneNumber(phone string) (User, error)
 	ReadByUsernanme(username string) (User, error)
+	IncrementFailedLogins(id string) (User, error)
+	UpdatePassword(id string, hash string) error
+	AdvanceTOTPStep(id string, step int64) (bool, error)
+	UseRecoveryCode(id string, hash string) (bool, error)
+	List(search string, role string, page Page) ([]User, int64, error)
+	FindDuplicates(field string) ([]Duplicate, error)
+	ListWithPhoneNumber() ([]User, error)
+	Migrate() (int64, error)
+	EnsureIndexes() error
 }
 
 // Repo is the struct that Implements the Repository Interface.
@@ -32,6 +46,54 @@ type Repo struct {
 	context context.Context
 }
 
+// `userCollation` compares strings case-insensitively. The unique indexes on the phone number, email
+// address and username are built with it, and lookups by those fields use it so that they can use the
+// indexes and match the way uniqueness is enforced.
+var userCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// The unique indexes of the users collection by the field they cover. Duplicate key errors name the
+// index, which tells which field clashed.
+var uniqueUserIndexes = []struct {
+	field string
+	name  string
+	err   error
+}{
+	{"phone_number", "users_phone_number_unique", pkg.ErrPhoneNumberTaken},
+	{"email", "users_email_unique", pkg.ErrEmailTaken},
+	{"username", "users_username_unique", pkg.ErrUsernameTaken},
+}
+
+// `userFieldRenames` maps the field names of version 1 user documents to the current ones.
+var userFieldRenames = bson.M{
+	"phonenumber":       "phone_number",
+	"profilepic":        "profile_pic",
+	"usertype":          "user_type",
+	"dateofbirth":       "date_of_birth",
+	"createdat":         "created_at",
+	"phoneverified":     "phone_verified",
+	"failedlogins":      "failed_logins",
+	"lockeduntil":       "locked_until",
+	"emailverified":     "email_verified",
+	"totpenabled":       "totp_enabled",
+	"totpsecret":        "totp_secret",
+	"totppendingsecret": "totp_pending_secret",
+	"totplaststep":      "totp_last_step",
+	"recoverycodes":     "recovery_codes",
+	"suspendedat":       "suspended_at",
+}
+
+// `legacyUserIndexes` are indexes of the users collection that name fields of the version 1 layout.
+// `Migrate` drops them so that `EnsureIndexes` can build their replacements.
+var legacyUserIndexes = []string{"users_phonenumber_unique"}
+
+// The Duplicate type is a value that more than one user has in a field that should be unique.
+// @property {string} Value - The value, lower cased.
+// @property {[]string} UserIDs - The IDs of the users that have it.
+type Duplicate struct {
+	Value   string   `bson:"_id"`
+	UserIDs []string `bson:"user_ids"`
+}
+
 // This function is used to fetch a user from the database with their email. It takes in an email
 // string as a parameter and returns a User object and an error. It searches for a user in the database
 // with the given email using the FindOne method of the MongoDB collection. If a user is found, it
@@ -39,7 +101,7 @@ type Repo struct {
 // indicating that the user was not found.
 func (s *Repo) ReadByEmail(email string) (User, error) {
 	var user User
-	err := s.db.FindOne(s.context, bson.M{"email": email}).Decode(&user)
+	err := s.db.FindOne(s.context, bson.M{"email": email}, options.FindOne().SetCollation(userCollation)).Decode(&user)
 	if err != nil {
 		return user, pkg.ErrUserNotFound
 	}
@@ -54,7 +116,7 @@ func (s *Repo) ReadByEmail(email string) (User, error) {
 
 func (s *Repo) ReadByPhoneNumber(phone string) (User, error) {
 	var user User
-	err := s.db.FindOne(s.context, bson.M{"phonenumber": phone}).Decode(&user)
+	err := s.db.FindOne(s.context, bson.M{"phone_number": phone}, options.FindOne().SetCollation(userCollation)).Decode(&user)
 	if err != nil {
 		return user, pkg.ErrUserNotFound
 	}
@@ -68,42 +130,41 @@ func (s *Repo) ReadByPhoneNumber(phone string) (User, error) {
 // indicating that the user was not found.
 func (s *Repo) ReadByUsernanme(username string) (User, error) {
 	var user User
-	err := s.db.FindOne(s.context, bson.M{"username": username}).Decode(&user)
+	err := s.db.FindOne(s.context, bson.M{"username": username}, options.FindOne().SetCollation(userCollation)).Decode(&user)
 	if err != nil {
-		return user, errors.New("user not found with this email")
+		return user, pkg.ErrUserNotFound
 	}
 	return user, nil
 }
 
 // This function is used to fetch a user from the database with their ID. It takes in an ID string as a
-// parameter and returns a User object and an error. It converts the ID string to a MongoDB ObjectID
-// using the `primitive.ObjectIDFromHex` function, and then searches for a user in the database with
-// the given ID using the `FindOne` method of the MongoDB collection. If a user is found, it decodes
-// the result into a User object and returns it. If no user is found, it returns an error indicating
-// that the user was not found.
+// parameter and returns a User object and an error. User IDs are UUID strings, so the ID is matched
+// against `_id` as it is. If a user is found, it decodes the result into a User object and returns it.
+// If no user is found, it returns `pkg.ErrUserNotFound`.
 func (s *Repo) ReadByID(id string) (User, error) {
-	oid, _ := primitive.ObjectIDFromHex(id)
-	var user User
-	err := s.db.FindOne(s.context, bson.M{"_id": oid}).Decode(&user)
-	if err != nil {
-		return user, err
-	}
-	return user, nil
+	return s.Read(id)
 }
 
 // This function is creating a new user in the database. It takes an `InUser` object as input, which is
 // a struct that contains the necessary information to create a new user. It converts this `InUser`
 // object to a `User` object using the `ToUser()` method, and then inserts this `User` object into the
 // MongoDB collection using the `InsertOne()` method. If there is an error during the insertion, it
-// returns the error. Otherwise, it returns the newly created `User` object.
+// returns the error; a phone number, email address or username that is already taken returns the
+// matching conflict error such as `pkg.ErrEmailTaken`. Otherwise, it returns the newly created `User`
+// object. The password in `in` must already be hashed.
 func (s *Repo) Create(in InUser) (User, error) {
-	user := in.ToUser()
+	return s.Insert(in.ToUser())
+}
+
+// This function inserts an already built `User` object into the MongoDB collection, stamped with the
+// current `UserSchemaVersion`. It suits users that sign up without a password.
+func (s *Repo) Insert(user User) (User, error) {
+	user.SchemaVersion = UserSchemaVersion
 	_, err := s.db.InsertOne(s.context, user)
 	if err != nil {
-		return user, err
+		return user, duplicateKeyError(err)
 	}
 	return user, nil
-
 }
 
 // `func (s *Repo) Read(id string) (User, error)` is a method of the `Repo` struct that implements the
@@ -115,7 +176,7 @@ func (s *Repo) Read(id string) (User, error) {
 	var user User
 	err := s.db.FindOne(s.context, bson.M{"_id": id}).Decode(&user)
 	if err != nil {
-		return user, errors.New("user not found with this id")
+		return user, pkg.ErrUserNotFound
 	}
 	return user, nil
 }
@@ -124,23 +185,114 @@ func (s *Repo) Read(id string) (User, error) {
 // update as input. It searches for a user in the database with the given ID using the
 // `FindOneAndUpdate` method of the MongoDB collection, and updates the fields specified in the input
 // map. If the update is successful, it returns the updated `User` object. If there is an error during
-// the update, it returns the error.
+// the update, it returns the error. Taking a phone number, email address or username another user has
+// returns the matching conflict error.
 func (s *Repo) Update(id string, upd map[string]interface{}) (User, error) {
 	var u User
 	if err := s.db.FindOneAndUpdate(s.context, bson.M{"_id": id}, upd).Decode(&u); err != nil {
-		return u, err
+		return u, duplicateKeyError(err)
 	}
 	return u, nil
 }
 
-// `func (s *Repo) Delete(id int) bool` is a method of the `Repo` struct that implements the
-// `Repository` interface. It takes an `id` of type `int` as input and returns a `bool`.
-func (s *Repo) Delete(id int) bool {
+// This function atomically increments the failed login counter of the user with the given ID and
+// returns the user as it is after the update, so concurrent failed logins are all counted.
+func (s *Repo) IncrementFailedLogins(id string) (User, error) {
+	var u User
+	err := s.db.FindOneAndUpdate(s.context,
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"failed_logins": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&u)
+	return u, err
+}
+
+// This function replaces the password hash of the user with the given ID. It returns
+// `pkg.ErrUserNotFound` if no user has the ID.
+func (s *Repo) UpdatePassword(id string, hash string) error {
+	res, err := s.db.UpdateOne(s.context, bson.M{"_id": id}, bson.M{"$set": bson.M{"password": hash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return pkg.ErrUserNotFound
+	}
+	return nil
+}
+
+// This function records `step` as the last accepted TOTP time step of the user with the given ID. The
+// update only applies if the stored step is older, and it reports whether it applied, so of two
+// concurrent logins with the same code only one succeeds.
+func (s *Repo) AdvanceTOTPStep(id string, step int64) (bool, error) {
+	filter := bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"totp_last_step": bson.M{"$lt": step}},
+			bson.M{"totp_last_step": bson.M{"$exists": false}},
+		},
+	}
+	res, err := s.db.UpdateOne(s.context, filter, bson.M{"$set": bson.M{"totp_last_step": step}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// This function removes the recovery code with the given hash from the user with the given ID and
+// reports whether the user still had it, so a recovery code can only be used once even by concurrent
+// logins.
+func (s *Repo) UseRecoveryCode(id string, hash string) (bool, error) {
+	res, err := s.db.UpdateOne(s.context,
+		bson.M{"_id": id, "recovery_codes": hash},
+		bson.M{"$pull": bson.M{"recovery_codes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// `func (s *Repo) Delete(id string) (bool, error)` is a method of the `Repo` struct that implements the
+// `Repository` interface. It removes the user with the given ID and reports whether a user was
+// removed.
+func (s *Repo) Delete(id string) (bool, error) {
 	delete, err := s.db.DeleteOne(s.context, bson.M{"_id": id})
 	if err != nil {
-		return false
+		return false, err
+	}
+	return delete.DeletedCount == 1, nil
+}
+
+// This function returns one page of users, newest first, together with the total number of matching
+// users. `search` is matched case-insensitively against the name, email, phone number and username,
+// and `role` restricts the list to one user type. Empty values match every user.
+func (s *Repo) List(search string, role string, page Page) ([]User, int64, error) {
+	filter := bson.M{}
+	if search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"name": pattern},
+			bson.M{"email": pattern},
+			bson.M{"phone_number": pattern},
+			bson.M{"username": pattern},
+		}
+	}
+	if role != "" {
+		filter["user_type"] = role
+	}
+	total, err := s.db.CountDocuments(s.context, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cur, err := s.db.Find(s.context, filter, page.findOptions().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, 0, err
 	}
-	return delete.DeletedCount == 1
+	users := []User{}
+	if err := cur.All(s.context, &users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
 }
 
 // The function returns a new instance of a Repository interface implementation with a MongoDB database
@@ -149,3 +301,128 @@ func NewRepo(db *mongo.Database) Repository {
 	ctx := context.TODO()
 	return &Repo{db: db.Collection("users"), context: ctx}
 }
+
+// This function returns the values of `field` that more than one user has, compared the way the unique
+// indexes compare them. It is used to find the accounts that keep a unique index from being built.
+func (s *Repo) FindDuplicates(field string) ([]Duplicate, error) {
+	cur, err := s.db.Aggregate(s.context, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{field: bson.M{"$gt": ""}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      bson.M{"$toLower": "$" + field},
+			"user_ids": bson.M{"$push": "$_id"},
+			"count":    bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	dups := []Duplicate{}
+	if err := cur.All(s.context, &dups); err != nil {
+		return nil, err
+	}
+	return dups, nil
+}
+
+// This function returns the ID and phone number of every user that has a phone number. It is used by
+// the migration that rewrites stored numbers to E.164.
+func (s *Repo) ListWithPhoneNumber() ([]User, error) {
+	cur, err := s.db.Find(s.context, bson.M{"phone_number": bson.M{"$gt": ""}},
+		options.Find().SetProjection(bson.M{"_id": 1, "phone_number": 1}))
+	if err != nil {
+		return nil, err
+	}
+	users := []User{}
+	if err := cur.All(s.context, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// This function upgrades user documents written with an older layout to `UserSchemaVersion` and
+// returns the number of documents it changed. Version 1 documents have their fields renamed to the
+// names of the bson tags; `$rename` skips fields a document does not have. Their user type was chosen
+// at sign up before roles were enforced, so an admin user type is downgraded to client and admins have
+// to be granted again with `cmd/grantadmin`. A trainer user type is kept, written as the exact role
+// name, and logged so that an admin can review it. Indexes on the old field names are dropped. It is
+// safe to run on every start, since current documents are left alone.
+func (s *Repo) Migrate() (int64, error) {
+	v1 := bson.M{"$or": bson.A{
+		bson.M{"schema_version": bson.M{"$exists": false}},
+		bson.M{"schema_version": bson.M{"$lt": 2}},
+	}}
+	legacyType := func(role string) bson.M {
+		return bson.M{"$and": bson.A{v1, bson.M{"usertype": primitive.Regex{Pattern: `^\s*` + role + `\s*$`, Options: "i"}}}}
+	}
+	if _, err := s.db.UpdateMany(s.context, legacyType(RoleAdmin), bson.M{"$set": bson.M{"usertype": RoleClient}}); err != nil {
+		return 0, err
+	}
+	cur, err := s.db.Find(s.context, legacyType(RoleTrainer), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	trainers := []User{}
+	if err := cur.All(s.context, &trainers); err != nil {
+		return 0, err
+	}
+	for _, trainer := range trainers {
+		log.Printf("auth: user %s keeps the trainer user type chosen at sign up before roles were enforced; review it with POST /api/admin/users/%s/role", trainer.ID, trainer.ID)
+	}
+	if _, err := s.db.UpdateMany(s.context, legacyType(RoleTrainer), bson.M{"$set": bson.M{"usertype": RoleTrainer}}); err != nil {
+		return 0, err
+	}
+	res, err := s.db.UpdateMany(s.context, v1, bson.M{
+		"$rename": userFieldRenames,
+		"$set":    bson.M{"schema_version": UserSchemaVersion},
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range legacyUserIndexes {
+		if _, err := s.db.Indexes().DropOne(s.context, name); err != nil && !isIndexNotFound(err) {
+			return res.ModifiedCount, err
+		}
+	}
+	return res.ModifiedCount, nil
+}
+
+// The function reports whether dropping an index failed because the index or the collection does not
+// exist.
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 27 || cmdErr.Code == 26)
+}
+
+// This function creates the unique indexes on the phone number, email address and username. They are
+// partial, so any number of users can leave a field empty, and case-insensitive. Building them fails
+// while duplicates exist; `cmd/userdups` lists them.
+func (s *Repo) EnsureIndexes() error {
+	models := make([]mongo.IndexModel, 0, len(uniqueUserIndexes))
+	for _, idx := range uniqueUserIndexes {
+		models = append(models, mongo.IndexModel{
+			Keys: bson.M{idx.field: 1},
+			Options: options.Index().
+				SetName(idx.name).
+				SetUnique(true).
+				SetCollation(userCollation).
+				SetPartialFilterExpression(bson.M{idx.field: bson.M{"$gt": ""}}),
+		})
+	}
+	_, err := s.db.Indexes().CreateMany(s.context, models)
+	return err
+}
+
+// The function turns a duplicate key error on one of the unique indexes into the conflict error of
+// the field. Other errors are returned as they are.
+func duplicateKeyError(err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	for _, idx := range uniqueUserIndexes {
+		if strings.Contains(err.Error(), idx.name) {
+			return idx.err
+		}
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sharir/pkg"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// userFields returns the field names of the user documents, read from the bson tags of User.
+func userFields() map[string]bool {
+	fields := map[string]bool{}
+	typ := reflect.TypeOf(User{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("bson"), ",")[0]
+		fields[name] = true
+	}
+	return fields
+}
+
+func TestUserDocumentFields(t *testing.T) {
+	fields := userFields()
+	for _, idx := range uniqueUserIndexes {
+		if !fields[idx.field] {
+			t.Errorf("unique index on %q, which users do not have", idx.field)
+		}
+	}
+	for from, to := range userFieldRenames {
+		if !fields[to.(string)] {
+			t.Errorf("%q is renamed to %q, which users do not have", from, to)
+		}
+	}
+
+	in := InUser{Name: "Asha", PhoneNumber: testPhone, Email: "asha@example.com", Username: "asha"}
+	raw, err := bson.Marshal(in.ToUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	// These are the fields `ReadByID`, `ReadByPhoneNumber`, `ReadByEmail` and `ReadByUsernanme` query.
+	want := map[string]string{"phone_number": in.PhoneNumber, "email": in.Email, "username": in.Username}
+	for field, value := range want {
+		if doc[field] != value {
+			t.Errorf("document field %q is %v, want %q", field, doc[field], value)
+		}
+	}
+	if id, _ := doc["_id"].(string); id == "" {
+		t.Error("document has no string _id")
+	}
+}
+
+// testDB returns an empty database on the MongoDB server named by `MONGO_TEST_URI` and drops it when
+// the test ends. Tests that need a server are skipped if the variable is not set.
+func testDB(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database(fmt.Sprintf("sharir_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	return db
+}
+
+func TestRepoLookupsFindCreatedUser(t *testing.T) {
+	repo := NewRepo(testDB(t))
+	if err := repo.EnsureIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	created, err := repo.Create(InUser{Name: "Asha", PhoneNumber: testPhone, Email: "asha@example.com", Username: "Asha"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups := map[string]func() (User, error){
+		"ReadByID":          func() (User, error) { return repo.ReadByID(created.ID) },
+		"ReadByPhoneNumber": func() (User, error) { return repo.ReadByPhoneNumber(testPhone) },
+		"ReadByEmail":       func() (User, error) { return repo.ReadByEmail("ASHA@example.com") },
+		"ReadByUsernanme":   func() (User, error) { return repo.ReadByUsernanme("asha") },
+	}
+	for name, lookup := range lookups {
+		user, err := lookup()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if user.ID != created.ID || user.SchemaVersion != UserSchemaVersion {
+			t.Errorf("%s: got %+v", name, user)
+		}
+	}
+	if _, err := repo.ReadByEmail("other@example.com"); err != pkg.ErrUserNotFound {
+		t.Errorf("unknown email: got %v", err)
+	}
+	if _, err := repo.Create(InUser{Name: "Other", Email: "Asha@Example.com"}); err != pkg.ErrEmailTaken {
+		t.Errorf("taken email: got %v", err)
+	}
+}
+
+func TestRepoMigrate(t *testing.T) {
+	db := testDB(t)
+	repo := NewRepo(db)
+	_, err := db.Collection("users").InsertMany(context.Background(), []interface{}{
+		bson.M{"_id": "v1-client", "name": "Asha", "phonenumber": testPhone, "usertype": "client"},
+		bson.M{"_id": "v1-admin", "name": "Ravi", "email": "ravi@example.com", "usertype": "Admin"},
+		bson.M{"_id": "v1-trainer", "name": "Meera", "username": "meera", "usertype": " Trainer"},
+		bson.M{"_id": "v2-admin", "name": "Dev", "user_type": "admin", "schema_version": 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := repo.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 3 {
+		t.Errorf("%d documents migrated, want 3", migrated)
+	}
+	if err := repo.EnsureIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := repo.ReadByPhoneNumber(testPhone); err != nil || user.ID != "v1-client" {
+		t.Errorf("renamed phone number not found: %+v, %v", user, err)
+	}
+	want := map[string]string{"v1-client": RoleClient, "v1-admin": RoleClient, "v1-trainer": RoleTrainer, "v2-admin": RoleAdmin}
+	for id, role := range want {
+		user, err := repo.Read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.UserType != role || user.SchemaVersion != UserSchemaVersion {
+			t.Errorf("%s: user type %q, schema version %d", id, user.UserType, user.SchemaVersion)
+		}
+	}
+	if migrated, err = repo.Migrate(); err != nil || migrated != 0 {
+		t.Errorf("second run: migrated %d, err %v", migrated, err)
+	}
+}
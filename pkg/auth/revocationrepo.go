@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The RevokedToken type is the document stored in the `revoked_tokens` collection. A document keyed by
+// a `jti` revokes that single access token. A document keyed by `user:<id>` revokes every access token
+// of that user that was issued before `NotBefore`, and one keyed by `family:<id>` revokes every access
+// token of a session.
+// @property {string} ID - The `jti` of the revoked token, `user:<id>` for a user wide revocation or
+// `family:<id>` for a session.
+// @property {string} UserID - The ID of the user the token belongs to.
+// @property NotBefore - Only set on user wide revocations. Tokens issued before this time are rejected.
+// @property ExpiresAt - The time after which the revoked token would have expired anyway. MongoDB
+// removes the document through a TTL index once this time has passed.
+type RevokedToken struct {
+	ID        string    `bson:"_id"`
+	UserID    string    `bson:"user_id"`
+	NotBefore time.Time `bson:"not_before,omitempty"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// RevocationRepository defines the operations that can be performed on the access token revocation
+// store.
+type RevocationRepository interface {
+	Revoke(jti string, userID string, expiresAt time.Time) error
+	RevokeOnce(jti string, userID string, expiresAt time.Time) (bool, error)
+	RevokeUser(userID string, notBefore time.Time, expiresAt time.Time) error
+	RevokeFamily(familyID string, userID string, expiresAt time.Time) error
+	IsRevoked(jti string, userID string, familyID string, issuedAt time.Time) (bool, error)
+	EnsureIndexes() error
+}
+
+// RevocationRepo is the struct that implements the RevocationRepository interface on top of the
+// `revoked_tokens` collection. To create a RevocationRepo, use the NewRevocationRepo function.
+type RevocationRepo struct {
+	db      *mongo.Collection
+	context context.Context
+}
+
+// The function revokes the access token with the given `jti` until it expires.
+func (s *RevocationRepo) Revoke(jti string, userID string, expiresAt time.Time) error {
+	_, err := s.db.UpdateOne(s.context,
+		bson.M{"_id": jti},
+		bson.M{"$set": bson.M{"user_id": userID, "expires_at": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// The function revokes the token with the given `jti` and reports whether this call was the one that
+// revoked it. It is used to make single-use tokens, because only one of several concurrent calls can
+// insert the document.
+func (s *RevocationRepo) RevokeOnce(jti string, userID string, expiresAt time.Time) (bool, error) {
+	_, err := s.db.InsertOne(s.context, RevokedToken{ID: jti, UserID: userID, ExpiresAt: expiresAt})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// The function revokes every access token of the given user that was issued before `notBefore`. The
+// revocation is kept until `expiresAt`, after which no such token can still be valid.
+func (s *RevocationRepo) RevokeUser(userID string, notBefore time.Time, expiresAt time.Time) error {
+	_, err := s.db.UpdateOne(s.context,
+		bson.M{"_id": userRevocationID(userID)},
+		bson.M{"$set": bson.M{"user_id": userID, "not_before": notBefore, "expires_at": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// The function revokes every access token that was issued in the session with the given refresh token
+// family. Sessions are never resumed, so the revocation needs no cut-off and is kept until `expiresAt`.
+func (s *RevocationRepo) RevokeFamily(familyID string, userID string, expiresAt time.Time) error {
+	_, err := s.db.UpdateOne(s.context,
+		bson.M{"_id": familyRevocationID(familyID)},
+		bson.M{"$set": bson.M{"user_id": userID, "expires_at": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// The function reports whether the access token with the given `jti`, issued to `userID` at
+// `issuedAt` in the given family, has been revoked on its own, through a user wide revocation or
+// together with its session.
+func (s *RevocationRepo) IsRevoked(jti string, userID string, familyID string, issuedAt time.Time) (bool, error) {
+	ids := []string{jti, userRevocationID(userID)}
+	if familyID != "" {
+		ids = append(ids, familyRevocationID(familyID))
+	}
+	cur, err := s.db.Find(s.context, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return false, err
+	}
+	var docs []RevokedToken
+	if err := cur.All(s.context, &docs); err != nil {
+		return false, err
+	}
+	for _, doc := range docs {
+		if doc.ID == jti || doc.ID == familyRevocationID(familyID) || issuedAt.Before(doc.NotBefore) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// The function creates the TTL index that lets MongoDB remove revocations once the tokens they refer
+// to have expired.
+func (s *RevocationRepo) EnsureIndexes() error {
+	_, err := s.db.Indexes().CreateOne(s.context, mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// The function returns the ID of the document that holds the user wide revocation of a user.
+func userRevocationID(userID string) string {
+	return "user:" + userID
+}
+
+// The function returns the ID of the document that revokes the access tokens of a session.
+func familyRevocationID(familyID string) string {
+	return "family:" + familyID
+}
+
+// The function returns a new instance of a RevocationRepository interface implementation with a
+// MongoDB database connection.
+func NewRevocationRepo(db *mongo.Database) RevocationRepository {
+	ctx := context.TODO()
+	return &RevocationRepo{db: db.Collection("revoked_tokens"), context: ctx}
+}
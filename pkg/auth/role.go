@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"sharir/pkg"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The roles a user can have. They are stored in `User.UserType` and carried in the `role` claim of
+// access tokens. Everybody signs up as a client; the trainer and admin roles are privileged and can only
+// be granted by an admin.
+const (
+	RoleClient  = "client"
+	RoleTrainer = "trainer"
+	RoleAdmin   = "admin"
+)
+
+// The function reports whether `role` is one of the defined roles.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleClient, RoleTrainer, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// The function returns the role of the user. Only the exact role names are trusted: they are only
+// written by `SetRole`, `cmd/grantadmin` and sign up, while the privileged user types clients could
+// choose before roles were enforced are rewritten by `Repo.Migrate`. Anything else counts as a client.
+func roleOf(user User) string {
+	if !ValidRole(user.UserType) {
+		return RoleClient
+	}
+	return user.UserType
+}
+
+// The function returns the role a sign up asked for. Only the client role can be chosen at sign up; an
+// empty user type means client.
+func signUpRole(userType string) (string, error) {
+	role := strings.ToLower(strings.TrimSpace(userType))
+	if role == "" {
+		return RoleClient, nil
+	}
+	if !ValidRole(role) {
+		return "", pkg.ErrInvalidRole
+	}
+	if role != RoleClient {
+		return "", pkg.ErrRoleNotAllowed
+	}
+	return role, nil
+}
+
+// The `SetRole` function changes the role of a user on behalf of the admin described by `actor`.
+// Admins cannot change their own role, so the last admin cannot lock everybody out. The user's
+// sessions are revoked so that the new role is in effect on the next login.
+func (s *Svc) SetRole(actor AccessClaims, userID string, role string) error {
+	if actor.Role != RoleAdmin || actor.UserID == userID {
+		return pkg.ErrRoleNotAllowed
+	}
+	if !ValidRole(role) {
+		return pkg.ErrInvalidRole
+	}
+	if _, err := s.repo.Read(userID); err != nil {
+		return pkg.ErrUserNotFound
+	}
+	if _, err := s.repo.Update(userID, map[string]interface{}{"$set": bson.M{"user_type": role}}); err != nil {
+		return err
+	}
+	if err := s.revokeUserSessions(userID); err != nil {
+		return err
+	}
+	return s.audit(actor, AuditUserRole, userID, map[string]interface{}{"role": role})
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"sharir/pkg"
+	"testing"
+)
+
+func TestRoleOf(t *testing.T) {
+	tests := map[string]string{
+		"":          RoleClient,
+		"client":    RoleClient,
+		"trainer":   RoleTrainer,
+		"admin":     RoleAdmin,
+		"Admin":     RoleClient,
+		" admin":    RoleClient,
+		"superuser": RoleClient,
+	}
+	for userType, want := range tests {
+		if got := roleOf(User{UserType: userType}); got != want {
+			t.Errorf("user type %q: got %q, want %q", userType, got, want)
+		}
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.addUser(t, User{Name: "Admin", UserType: RoleAdmin}, "")
+	user := env.addUser(t, User{Name: "Asha", PhoneNumber: testPhone}, "asha-pass-2231")
+	tokens, err := env.svc.Login(testPhone, "asha-pass-2231", Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminClaims := AccessClaims{UserID: admin.ID, Role: RoleAdmin}
+	userClaims := env.accessClaims(t, tokens.AccessToken)
+	if err := env.svc.SetRole(userClaims, user.ID, RoleAdmin); err != pkg.ErrRoleNotAllowed {
+		t.Errorf("client granting a role: got %v", err)
+	}
+	if err := env.svc.SetRole(adminClaims, admin.ID, RoleClient); err != pkg.ErrRoleNotAllowed {
+		t.Errorf("admin changing their own role: got %v", err)
+	}
+	if err := env.svc.SetRole(adminClaims, user.ID, "owner"); err != pkg.ErrInvalidRole {
+		t.Errorf("unknown role: got %v", err)
+	}
+	if err := env.svc.SetRole(adminClaims, user.ID, RoleTrainer); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.user(t, user.ID).UserType; got != RoleTrainer {
+		t.Errorf("user type %q, want %q", got, RoleTrainer)
+	}
+	if revoked, _ := env.svc.IsRevoked(userClaims); !revoked {
+		t.Error("token carrying the old role still valid")
+	}
+	if len(env.audit.entries) != 1 || env.audit.entries[0].Action != AuditUserRole {
+		t.Errorf("audit log %+v", env.audit.entries)
+	}
+}
@@ -1,121 +1,597 @@
 package auth
 
 import (
+	"context"
 	"errors"
-	"os"
+	"log"
 	"sharir/pkg"
+	"sharir/pkg/configuration"
+	"sharir/pkg/mail"
+	"sharir/pkg/oidc"
+	"sharir/pkg/otp"
+	"sharir/pkg/password"
+	"sharir/pkg/phone"
+	"sharir/pkg/webauthn"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // The above code defines a Service interface with a SignUp method that takes an InUser input and
-// returns a string and an error.
+// returns a TokenPair and an error.
 // @property SignUp - SignUp is a method signature of an interface named Service. It takes an input
-// parameter of type InUser and returns two values - a string and an error. The purpose of this method
-// is to handle user sign up functionality.
+// parameter of type InUser and returns two values - a TokenPair and an error. The purpose of this
+// method is to handle user sign up functionality.
+// @property Refresh - Refresh exchanges a refresh token for a new TokenPair, rotating the refresh
+// token in the process.
+// @property Logout - Logout revokes the access token and the refresh token family of one session.
+// @property LogoutAll - LogoutAll revokes every access token and refresh token of the user.
+// @property IsRevoked - IsRevoked reports whether an otherwise valid access token has been revoked.
+// @property UnlockUser - UnlockUser lifts the temporary lock of an account.
+// @property SetRole - SetRole lets an admin change the role of a user.
+// @property ForgotPassword - ForgotPassword sends a password reset OTP to the phone number.
+// @property VerifyPasswordReset - VerifyPasswordReset exchanges a password reset OTP for a single-use
+// reset token.
+// @property ResetPassword - ResetPassword sets a new password using a reset token.
+// @property ChangePassword - ChangePassword replaces the password of a logged in user and signs out
+// every other session.
+// @property SendEmailVerification - SendEmailVerification emails a verification link to the user.
+// @property VerifyEmail - VerifyEmail marks the user's email as verified using the link's token.
+// @property IsEmailVerified - IsEmailVerified reports whether the user has verified the email.
+// @property SendMagicLink - SendMagicLink emails a passwordless login link to the address.
+// @property RedeemMagicLink - RedeemMagicLink logs a user in with the token of a magic link.
+// @property EnrollTOTP - EnrollTOTP starts TOTP enrollment and returns the secret and otpauth URI.
+// @property ConfirmTOTP - ConfirmTOTP enables TOTP and returns the recovery codes.
+// @property DisableTOTP - DisableTOTP turns TOTP off.
+// @property VerifyMFA - VerifyMFA completes a login that needs a second factor.
+// @property BeginPasskeyRegistration - BeginPasskeyRegistration starts registering a passkey.
+// @property FinishPasskeyRegistration - FinishPasskeyRegistration verifies and stores a new passkey.
+// @property BeginPasskeyLogin - BeginPasskeyLogin starts a passkey login.
+// @property FinishPasskeyLogin - FinishPasskeyLogin verifies a passkey login and issues tokens.
+// @property ListPasskeys - ListPasskeys returns the user's passkeys.
+// @property DeletePasskey - DeletePasskey removes one of the user's passkeys.
+// @property BeginSocialLogin - BeginSocialLogin returns the URL that starts a login with an identity
+// provider.
+// @property FinishSocialLogin - FinishSocialLogin completes a login with an identity provider and
+// reports whether a new user was created.
+// @property ListSessions - ListSessions returns the devices the user is signed in on.
+// @property RevokeSession - RevokeSession signs the user out of one session.
+// @property ListUsers - ListUsers returns a page of users for an admin.
+// @property GetUser - GetUser returns a single user for an admin.
+// @property SuspendUser - SuspendUser keeps a user from logging in.
+// @property ReactivateUser - ReactivateUser lifts a suspension.
+// @property DeleteUser - DeleteUser removes a user and everything tied to the account.
+// @property ListAuditLog - ListAuditLog returns a page of the admin audit log.
+// @property CreateAPIKey - CreateAPIKey issues a scoped API key for a partner or a batch job.
+// @property ListAPIKeys - ListAPIKeys returns a page of API keys.
+// @property RevokeAPIKey - RevokeAPIKey revokes an API key.
+// @property AuthenticateAPIKey - AuthenticateAPIKey checks the API key of a request.
+//
+// The methods that take an `actor` are admin actions; each of them is recorded in the audit log.
+//
+// Phone numbers can be given in any common format; they are normalized to E.164 before use. `Login`
+// takes a phone number, email address or username, see `ParseIdentifier`.
+//
+// Every method that logs a user in takes the Device the request came from and starts a new session
+// for it.
 type Service interface {
-	Login(email string, password string) (string, error)
-	LoginPhoneOtp(phone string) (string, error)
-	SignUp(in InUser) (string, error)
+	Login(identifier string, password string, device Device) (TokenPair, error)
+	LoginPhoneOtp(phone string, code string, device Device) (TokenPair, bool, error)
+	SignUp(in InUser, device Device) (TokenPair, error)
+	Refresh(refreshToken string, device Device) (TokenPair, error)
+	Logout(claims AccessClaims) error
+	LogoutAll(claims AccessClaims) error
+	IsRevoked(claims AccessClaims) (bool, error)
+	UnlockUser(actor AccessClaims, id string) error
+	SetRole(actor AccessClaims, userID string, role string) error
+	ForgotPassword(phone string) error
+	VerifyPasswordReset(phone string, code string) (string, error)
+	ResetPassword(resetToken string, password string) error
+	ChangePassword(claims AccessClaims, current string, password string) (TokenPair, error)
+	SendEmailVerification(userID string) error
+	VerifyEmail(token string) error
+	SendMagicLink(email string) error
+	RedeemMagicLink(token string, device Device) (TokenPair, error)
+	IsEmailVerified(userID string) (bool, error)
+	EnrollTOTP(userID string) (string, string, error)
+	ConfirmTOTP(userID string, code string) ([]string, error)
+	DisableTOTP(userID string, code string) error
+	VerifyMFA(mfaToken string, code string, device Device) (TokenPair, error)
+	BeginPasskeyRegistration(userID string) (webauthn.CreationOptions, string, error)
+	FinishPasskeyRegistration(userID string, session string, name string, resp webauthn.AttestationResponse) (Passkey, error)
+	BeginPasskeyLogin() (webauthn.RequestOptions, string, error)
+	FinishPasskeyLogin(session string, resp webauthn.AssertionResponse, device Device) (TokenPair, error)
+	ListPasskeys(userID string) ([]Passkey, error)
+	DeletePasskey(userID string, id string) error
+	BeginSocialLogin(ctx context.Context, provider string) (string, error)
+	FinishSocialLogin(ctx context.Context, provider string, state string, code string, device Device) (TokenPair, bool, error)
+	ListSessions(userID string) ([]Session, error)
+	RevokeSession(userID string, sessionID string) error
+	ListUsers(actor AccessClaims, q UserQuery) (UserPage, error)
+	GetUser(actor AccessClaims, userID string) (OutUser, error)
+	SuspendUser(actor AccessClaims, userID string, reason string) error
+	ReactivateUser(actor AccessClaims, userID string) error
+	DeleteUser(actor AccessClaims, userID string) error
+	ListAuditLog(actor AccessClaims, targetID string, page Page) (AuditPage, error)
+	CreateAPIKey(actor AccessClaims, name string, scopes []string, expiresAt time.Time) (string, APIKey, error)
+	ListAPIKeys(actor AccessClaims, page Page) (APIKeyPage, error)
+	RevokeAPIKey(actor AccessClaims, id string) error
+	AuthenticateAPIKey(raw string) (AccessClaims, error)
 }
 
-// The type Svc contains a pointer to a Repo.
-// @property repo - `repo` is a pointer to a `Repo` struct. It is likely used to access and manipulate
-// data stored in a database or other data storage system. The `Svc` struct may contain methods that
-// use the `repo` pointer to perform CRUD (create, read, update, delete) operations
+// The type Svc contains the repositories the service works with. They are held as interfaces so the
+// service can run against in-memory implementations in tests.
+// @property repo - `repo` is the `Repository` users are stored in. The `Svc` struct uses it to perform
+// CRUD (create, read, update, delete) operations on users.
+// @property tokens - `tokens` is the `TokenRepository` that stores issued refresh tokens.
+// @property revocations - `revocations` is the `RevocationRepository` that stores revoked access
+// tokens.
+// @property otp - `otp` is the OTP provider that phone OTP codes are verified with.
+// @property policy - `policy` is the password policy new passwords are checked against.
+// @property hasher - `hasher` hashes passwords and checks them against stored hashes.
+// @property mailer - `mailer` delivers emails such as verification links.
+// @property config - `config` holds the JWT secret and the token lifetimes.
 type Svc struct {
-	repo *Repo
+	repo        Repository
+	tokens      TokenRepository
+	revocations RevocationRepository
+	sessions    SessionRepository
+	passkeys    PasskeyRepository
+	oidcRepo    OIDCRepository
+	auditLog    AuditRepository
+	apiKeys     APIKeyRepository
+	magicLinks  MagicLinkRepository
+	otp         otp.OTPProvider
+	policy      *password.Policy
+	hasher      *password.Hasher
+	mailer      mail.Mailer
+	webauthn    webauthn.RelyingParty
+	oidc        map[string]*oidc.Client
+	config      configuration.Config
 }
 
 // The `SignUp` function is a method of the `Svc` struct that implements the `Service` interface. It
-// takes an `InUser` input parameter and returns a string and an error. It first checks if a user with
-// the same email already exists in the repository using the `ReadByEmail` method. If a user with the
-// same email exists, it returns an error. Otherwise, it creates a new user in the repository using the
-// `Create` method and generates a JWT token with the user's ID, email, and expiration time using the
-// `jwt` package. Finally, it returns the signed token as a string. This function is likely used for
-// user sign up and authentication.
-func (s *Svc) SignUp(in InUser) (string, error) {
-	user, err := s.repo.ReadByEmail(in.Email)
-	if !(err == pkg.ErrUserNotFound) && err != nil {
-		return "", err
+// takes an `InUser` input parameter and returns a TokenPair and an error. It creates a new user in the
+// repository using the `Create` method and issues an access token and a refresh token for the new
+// user. A phone number, email address or username that another account already has fails with the
+// matching conflict error, such as `pkg.ErrEmailTaken`. New users are always clients; asking for a
+// privileged role fails with `pkg.ErrRoleNotAllowed`. The password has to satisfy the password policy.
+// A verification link is emailed to the new user; failing to send it does not fail the sign up, since
+// the link can be requested again.
+func (s *Svc) SignUp(in InUser, device Device) (TokenPair, error) {
+	role, err := signUpRole(in.UserType)
+	if err != nil {
+		return TokenPair{}, err
 	}
-	if user.Email == in.Email {
-		return "", errors.New("user with id already exists")
+	in.UserType = role
+	if in.PhoneNumber != "" {
+		if in.PhoneNumber, err = s.normalizePhone(in.PhoneNumber); err != nil {
+			return TokenPair{}, err
+		}
 	}
-	create, err := s.repo.Create(in)
-	if err != nil {
-		return "", err
+	if err := s.policy.Check(in.Password, password.Personal{Name: in.Name, Username: in.Username, Email: in.Email, PhoneNumber: in.PhoneNumber}); err != nil {
+		return TokenPair{}, err
 	}
-	claims := jwt.MapClaims{
-		"userid": create.ID,
-		"email":  create.Email,
-		"exp":    time.Now().Add(time.Hour * 72).Unix(),
+	if in.Password, err = s.hasher.Hash(in.Password); err != nil {
+		return TokenPair{}, err
 	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	refresh, err := refreshToken.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	create, err := s.repo.Create(in)
 	if err != nil {
-		return "", err
+		return TokenPair{}, err
 	}
-	return refresh, nil
+	if create.Email != "" {
+		if err := s.SendEmailVerification(create.ID); err != nil {
+			log.Printf("auth: sending verification email to user %s: %v", create.ID, err)
+		}
+	}
+	return s.startSession(create, device)
 }
 
 // The `Login` function is a method of the `Svc` struct that implements the `Service` interface. It
-// takes a `phone` and `password` input parameters and returns a string and an error. It retrieves a
-// user from the repository using the `ReadByPhoneNumber` method, compares the hashed password with the
-// input password using `bcrypt.CompareHashAndPassword`, creates a JWT token with the user's ID, email,
-// and expiration time, and returns the signed token as a string. This function is likely used for user
-// authentication using a phone number and password verification.
-func (s *Svc) Login(phone string, password string) (string, error) {
-	user, err := s.repo.ReadByPhoneNumber(phone)
+// takes an `identifier` and `password` input parameters and returns a TokenPair and an error. The
+// identifier is a phone number, email address or username; it is parsed with `ParseIdentifier` and the
+// user is retrieved through the repository method of its kind. The input password is checked against
+// the stored hash, and an access token and a refresh token are issued for the user. Hashes made with
+// another algorithm or weaker parameters than the configured ones are replaced after a successful
+// check. Users with TOTP enabled get a `*pkg.MFARequiredError` carrying a challenge token instead of
+// tokens, and finish with `VerifyMFA`.
+//
+// Failed attempts are counted and lock the account, but the response does not reveal which
+// identifiers exist: unknown identifiers, accounts without a password and wrong passwords all return
+// `pkg.ErrInvalidCredentials`, and a password hash is computed in every case so that they take about
+// as long. A locked account returns a `*pkg.AccountLockedError` with the end of the lock only when the
+// correct password is given, so the user learns why the login fails and when to try again, while
+// someone guessing passwords cannot tell a locked account from a wrong guess. Attempts on a locked
+// account are not counted, so guessing cannot extend the lock.
+func (s *Svc) Login(identifier string, password string, device Device) (TokenPair, error) {
+	id, ok := ParseIdentifier(identifier, s.config.PhoneDefaultRegion)
+	if !ok {
+		s.hasher.Burn(password)
+		return TokenPair{}, pkg.ErrInvalidCredentials
+	}
+	user, err := s.readByIdentifier(id)
+	if err == pkg.ErrUserNotFound || (err == nil && user.Password == "") {
+		s.hasher.Burn(password)
+		return TokenPair{}, pkg.ErrInvalidCredentials
+	}
 	if err != nil {
-		return "", err
+		return TokenPair{}, err
 	}
-	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", err
+	if time.Now().Before(user.LockedUntil) {
+		if s.hasher.Verify(user.Password, password) != nil {
+			return TokenPair{}, pkg.ErrInvalidCredentials
+		}
+		return TokenPair{}, &pkg.AccountLockedError{Until: user.LockedUntil}
+	}
+	if err = s.hasher.Verify(user.Password, password); err != nil {
+		var locked *pkg.AccountLockedError
+		if lockErr := s.recordFailedLogin(user.ID); lockErr != nil && !errors.As(lockErr, &locked) {
+			return TokenPair{}, lockErr
+		}
+		return TokenPair{}, pkg.ErrInvalidCredentials
 	}
-	claims := jwt.MapClaims{
-		"userid": user.ID,
-		"email":  user.Email,
-		"exp":    time.Now().Add(time.Hour * 72).Unix(),
+	if s.hasher.NeedsRehash(user.Password) {
+		s.rehashPassword(user.ID, password)
+	}
+	if user.TOTPEnabled {
+		return TokenPair{}, s.mfaChallenge(user)
+	}
+	if user.FailedLogins > 0 {
+		if err := s.resetFailedLogins(user.ID); err != nil {
+			return TokenPair{}, err
+		}
+	}
+	return s.startSession(user, device)
+}
+
+// The function returns what is known about the user that the password policy keeps out of passwords.
+func personalOf(user User) password.Personal {
+	return password.Personal{Name: user.Name, Username: user.Username, Email: user.Email, PhoneNumber: user.PhoneNumber}
+}
+
+// The function replaces the stored password hash of the user with one made under the current
+// configuration. The password has just been checked, so a failure only means the old hash is kept
+// until the next login; it is logged and does not fail the login.
+func (s *Svc) rehashPassword(userID string, password string) {
+	hash, err := s.hasher.Hash(password)
+	if err == nil {
+		err = s.repo.UpdatePassword(userID, hash)
 	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	refresh, err := refreshToken.SignedString([]byte(os.Getenv("JWT_SECRET")))
 	if err != nil {
-		return "", err
+		log.Printf("auth: rehashing the password of user %s: %v", userID, err)
 	}
-	return refresh, nil
+}
 
+// The function counts a failed login for the user. Once the number of consecutive failures reaches
+// the lockout threshold the account is locked, starting with the base lockout duration and doubling it
+// with every further failure up to the maximum. It returns a `*pkg.AccountLockedError` when the
+// account has just been locked.
+func (s *Svc) recordFailedLogin(userID string) error {
+	user, err := s.repo.IncrementFailedLogins(userID)
+	if err != nil {
+		return err
+	}
+	over := user.FailedLogins - s.config.LockoutThreshold
+	if over < 0 {
+		return nil
+	}
+	lock := s.config.LockoutMaxDuration
+	if over < 32 && s.config.LockoutBaseDuration<<over < lock {
+		lock = s.config.LockoutBaseDuration << over
+	}
+	until := time.Now().Add(lock)
+	if _, err := s.repo.Update(userID, map[string]interface{}{"$set": bson.M{"locked_until": until}}); err != nil {
+		return err
+	}
+	return &pkg.AccountLockedError{Until: until}
+}
+
+// The function clears the failed login counter and any lock of the user.
+func (s *Svc) resetFailedLogins(userID string) error {
+	_, err := s.repo.Update(userID, map[string]interface{}{"$set": bson.M{"failed_logins": 0, "locked_until": time.Time{}}})
+	return err
+}
+
+// The `UnlockUser` function lifts the lock of an account and clears its failed login counter. It is
+// used by admins to unlock an account before the lock expires. API keys cannot unlock admins.
+func (s *Svc) UnlockUser(actor AccessClaims, id string) error {
+	if _, err := s.readTarget(actor, id); err != nil {
+		return err
+	}
+	if err := s.resetFailedLogins(id); err != nil {
+		return err
+	}
+	return s.audit(actor, AuditUserUnlock, id, nil)
 }
 
 // The `LoginPhoneOtp` function is a method of the `Svc` struct that implements the `Service`
-// interface. It takes a `phone` string input parameter and returns a string and an error. It retrieves
-// a user from the repository using the `ReadByPhoneNumber` method, creates a JWT token with the user's
-// ID, email, and expiration time, and returns the signed token as a string. This function is likely
-// used for user authentication using a phone number and OTP (one-time password) verification.
-func (s *Svc) LoginPhoneOtp(phone string) (string, error) {
+// interface. It takes a `phone` and a `code` input parameter and returns a TokenPair, whether a new
+// user was created, and an error. It first verifies the code with the OTP provider and only once the
+// provider has approved it retrieves the user using the `ReadByPhoneNumber` method and issues an
+// access token and a refresh token. A code that is not approved returns the provider's error and no
+// token is issued. If no user has the phone number yet, a minimal passwordless user is created so the
+// client can complete the profile afterwards. Users with TOTP enabled get a `*pkg.MFARequiredError`
+// instead of tokens, just like after a password login.
+func (s *Svc) LoginPhoneOtp(phone string, code string, device Device) (TokenPair, bool, error) {
+	phone, err := s.normalizePhone(phone)
+	if err != nil {
+		return TokenPair{}, false, err
+	}
+	if err := s.otp.VerifyOTP(phone, code); err != nil {
+		return TokenPair{}, false, err
+	}
 	user, err := s.repo.ReadByPhoneNumber(phone)
+	if err == pkg.ErrUserNotFound {
+		user, err = s.repo.Insert(newPhoneUser(phone))
+		if err != nil {
+			return TokenPair{}, false, err
+		}
+		tokens, err := s.startSession(user, device)
+		return tokens, true, err
+	}
+	if err != nil {
+		return TokenPair{}, false, err
+	}
+	if !user.PhoneVerified {
+		if _, err := s.repo.Update(user.ID, map[string]interface{}{"$set": bson.M{"phone_verified": true}}); err != nil {
+			return TokenPair{}, false, err
+		}
+		user.PhoneVerified = true
+	}
+	if user.TOTPEnabled {
+		return TokenPair{}, false, s.mfaChallenge(user)
+	}
+	tokens, err := s.startSession(user, device)
+	return tokens, false, err
+}
+
+// The `Refresh` function exchanges a refresh token for a new TokenPair. The presented token is marked
+// as used and a new refresh token is issued in the same family. If a token that has already been used
+// is presented again, the token has most likely been stolen, so the whole family is revoked and
+// `pkg.ErrRefreshTokenReused` is returned. The session records the device and time of every refresh.
+func (s *Svc) Refresh(refreshToken string, device Device) (TokenPair, error) {
+	rt, err := s.tokens.MarkUsed(hashToken(refreshToken))
+	if err == mongo.ErrNoDocuments {
+		return TokenPair{}, pkg.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if rt.Revoked {
+		return TokenPair{}, pkg.ErrInvalidRefreshToken
+	}
+	if rt.Used {
+		if err := s.endSession(rt.UserID, rt.FamilyID); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, pkg.ErrRefreshTokenReused
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		return TokenPair{}, pkg.ErrInvalidRefreshToken
+	}
+	user, err := s.repo.Read(rt.UserID)
+	if err != nil {
+		return TokenPair{}, pkg.ErrInvalidRefreshToken
+	}
+	if user.Suspended {
+		return TokenPair{}, pkg.ErrAccountSuspended
+	}
+	tokens, err := s.issueTokens(user, rt.FamilyID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	now := time.Now()
+	if err := s.sessions.Touch(rt.FamilyID, device, now, now.Add(s.config.RefreshTokenTTL)); err != nil {
+		return TokenPair{}, err
+	}
+	return tokens, nil
+}
+
+// The `Logout` function ends a single session. It revokes the access token the request was made with
+// and every access token and refresh token of the session the access token was issued in.
+func (s *Svc) Logout(claims AccessClaims) error {
+	if err := s.revocations.Revoke(claims.ID, claims.UserID, claims.ExpiresAt); err != nil {
+		return err
+	}
+	if claims.FamilyID == "" {
+		return nil
+	}
+	return s.endSession(claims.UserID, claims.FamilyID)
+}
+
+// The `LogoutAll` function ends every session of the user. It rejects every access token of the user
+// that was issued before now and revokes all of the user's refresh tokens.
+func (s *Svc) LogoutAll(claims AccessClaims) error {
+	if err := s.revocations.Revoke(claims.ID, claims.UserID, claims.ExpiresAt); err != nil {
+		return err
+	}
+	return s.revokeUserSessions(claims.UserID)
+}
+
+// The function revokes every access token and refresh token that has been issued to the given user so
+// far. The cut-off of the user wide revocation is truncated to whole seconds because that is the
+// precision of the `iat` claim, so tokens issued right afterwards are not caught by it. That leaves the
+// tokens issued earlier in the same second, which the cut-off does not catch either, so the sessions
+// of the user are revoked one by one as well. Every session is stored before its first token is
+// issued, so listing them after the cut-off has been set finds the session of every such token. The
+// revocations only need to outlive the longest possible access token.
+func (s *Svc) revokeUserSessions(userID string) error {
+	now := time.Now().Truncate(time.Second)
+	expiresAt := now.Add(s.config.AccessTokenTTL)
+	if err := s.revocations.RevokeUser(userID, now, expiresAt); err != nil {
+		return err
+	}
+	sessions, err := s.sessions.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, sess := range sessions {
+		if err := s.revocations.RevokeFamily(sess.ID, userID, expiresAt); err != nil {
+			return err
+		}
+	}
+	if err := s.tokens.RevokeUser(userID); err != nil {
+		return err
+	}
+	return s.sessions.DeleteUser(userID)
+}
+
+// The `IsRevoked` function reports whether the access token described by `claims` has been revoked.
+// Tokens without a `jti` predate revocation support and are always treated as revoked.
+func (s *Svc) IsRevoked(claims AccessClaims) (bool, error) {
+	if claims.ID == "" {
+		return true, nil
+	}
+	return s.revocations.IsRevoked(claims.ID, claims.UserID, claims.FamilyID, claims.IssuedAt)
+}
+
+// The `ForgotPassword` function starts a password reset by sending an OTP to the phone number of the
+// account. Nothing is sent if no account has the phone number.
+func (s *Svc) ForgotPassword(phone string) error {
+	phone, err := s.normalizePhone(phone)
+	if err != nil {
+		return err
+	}
+	if _, err := s.repo.ReadByPhoneNumber(phone); err != nil {
+		return nil
+	}
+	return s.otp.SendOTP(phone)
+}
+
+// The function returns the E.164 form of a phone number given by a client, reading numbers without a
+// country code as numbers of `PhoneDefaultRegion`. Every phone number is stored and sent to the OTP
+// provider in this form.
+func (s *Svc) normalizePhone(raw string) (string, error) {
+	normalized, err := phone.Normalize(raw, s.config.PhoneDefaultRegion)
+	if err != nil {
+		return "", pkg.ErrInvalidPhoneNumber
+	}
+	return normalized, nil
+}
+
+// The `VerifyPasswordReset` function checks the OTP that was sent by `ForgotPassword`. Once the OTP
+// provider has approved the code it returns a reset token, a single-use grant that allows setting a new
+// password within `PasswordResetTTL`.
+func (s *Svc) VerifyPasswordReset(phone string, code string) (string, error) {
+	phone, err := s.normalizePhone(phone)
 	if err != nil {
 		return "", err
 	}
-	claims := jwt.MapClaims{
-		"userid": user.ID,
-		"email":  user.Email,
-		"exp":    time.Now().Add(time.Hour * 72).Unix(),
+	if err := s.otp.VerifyOTP(phone, code); err != nil {
+		return "", err
 	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	refresh, err := refreshToken.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	user, err := s.repo.ReadByPhoneNumber(phone)
 	if err != nil {
 		return "", err
 	}
-	return refresh, nil
+	return s.signGrant(user.ID, PurposePasswordReset, s.config.PasswordResetTTL, nil)
+}
 
+// The `ResetPassword` function consumes a reset token and replaces the user's password hash through
+// `Repo.Update`. The lockout counter is cleared and every existing session of the user is revoked, so
+// whoever knew the old password is signed out. The token is only used up once the new password has
+// passed the policy, so the user can try another password with the same token.
+func (s *Svc) ResetPassword(resetToken string, password string) error {
+	claims, err := s.parseGrant(resetToken, PurposePasswordReset)
+	if err != nil {
+		return err
+	}
+	user, err := s.repo.Read(claims.UserID)
+	if err != nil {
+		return pkg.ErrInvalidGrant
+	}
+	if err := s.policy.Check(password, personalOf(user)); err != nil {
+		return err
+	}
+	if _, err := s.consumeGrant(resetToken, PurposePasswordReset); err != nil {
+		return err
+	}
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	upd := bson.M{"password": hash, "failed_logins": 0, "locked_until": time.Time{}}
+	if _, err := s.repo.Update(claims.UserID, map[string]interface{}{"$set": upd}); err != nil {
+		return err
+	}
+	return s.revokeUserSessions(claims.UserID)
 }
 
-// The function creates a new instance of a service with a given repository.
-func NewAuthService(repo *Repo) Service {
+// The `ChangePassword` function replaces the password of the user the access token belongs to. The
+// current password has to be given and the new one has to satisfy the password policy. Every session
+// of the user is revoked, and a new TokenPair is returned so the session that made the change stays
+// signed in.
+func (s *Svc) ChangePassword(claims AccessClaims, current string, password string) (TokenPair, error) {
+	user, err := s.repo.Read(claims.UserID)
+	if err != nil {
+		return TokenPair{}, pkg.ErrUserNotFound
+	}
+	if err := s.hasher.Verify(user.Password, current); err != nil {
+		return TokenPair{}, pkg.ErrIncorrectPassword
+	}
+	if err := s.policy.Check(password, personalOf(user)); err != nil {
+		return TokenPair{}, err
+	}
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if err := s.repo.UpdatePassword(user.ID, hash); err != nil {
+		return TokenPair{}, err
+	}
+	device := Device{}
+	if sess, err := s.sessions.Read(claims.FamilyID); err == nil {
+		device = Device{Name: sess.DeviceName, UserAgent: sess.UserAgent, IP: sess.IP}
+	}
+	if err := s.revokeUserSessions(user.ID); err != nil {
+		return TokenPair{}, err
+	}
+	return s.startSession(user, device)
+}
+
+// The function creates a new instance of a service with the given repositories, OTP provider, password
+// policy, mailer and configuration. The passkey relying party and the identity provider clients are
+// built from the configuration.
+func NewAuthService(repo Repository, tokens TokenRepository, revocations RevocationRepository, sessions SessionRepository, passkeys PasskeyRepository, oidcRepo OIDCRepository, auditLog AuditRepository, apiKeys APIKeyRepository, magicLinks MagicLinkRepository, otpProvider otp.OTPProvider, policy *password.Policy, hasher *password.Hasher, mailer mail.Mailer, config configuration.Config) Service {
 	return &Svc{
-		repo: repo,
+		repo:        repo,
+		tokens:      tokens,
+		revocations: revocations,
+		sessions:    sessions,
+		passkeys:    passkeys,
+		oidcRepo:    oidcRepo,
+		auditLog:    auditLog,
+		apiKeys:     apiKeys,
+		magicLinks:  magicLinks,
+		otp:         otpProvider,
+		policy:      policy,
+		hasher:      hasher,
+		mailer:      mailer,
+		webauthn: webauthn.RelyingParty{
+			ID:      config.WebAuthnRPID,
+			Name:    config.WebAuthnRPName,
+			Origins: config.WebAuthnOrigins,
+			Timeout: config.WebAuthnTimeout,
+		},
+		oidc:   oidcClients(config.OIDCProviders),
+		config: config,
+	}
+}
+
+// The function creates a client for every configured identity provider, keyed by provider name.
+func oidcClients(providers []configuration.OIDCProvider) map[string]*oidc.Client {
+	clients := make(map[string]*oidc.Client, len(providers))
+	for _, p := range providers {
+		clients[p.Name] = oidc.NewClient(oidc.Provider{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+			ResponseMode: p.ResponseMode,
+		}, nil)
 	}
+	return clients
 }
@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"errors"
+	"sharir/pkg"
+	"testing"
+	"time"
+)
+
+const testPhone = "+919876543210"
+
+func TestLoginPhoneOtpApproved(t *testing.T) {
+	env := newTestEnv(t)
+	code := env.sendCode(t, testPhone)
+	tokens, isNew, err := env.svc.LoginPhoneOtp("98765 43210", code, Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNew {
+		t.Error("no new user reported for an unknown number")
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("no tokens issued: %+v", tokens)
+	}
+	user, err := env.users.ReadByPhoneNumber(testPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims := env.accessClaims(t, tokens.AccessToken); claims.UserID != user.ID {
+		t.Errorf("token issued to %q, want %q", claims.UserID, user.ID)
+	}
+	if !user.PhoneVerified {
+		t.Error("phone number not marked as verified")
+	}
+
+	code = env.sendCode(t, testPhone)
+	if _, isNew, err = env.svc.LoginPhoneOtp(testPhone, code, Device{}); err != nil || isNew {
+		t.Fatalf("second login: isNew %v, err %v", isNew, err)
+	}
+}
+
+func TestLoginPhoneOtpNotApproved(t *testing.T) {
+	tests := []struct {
+		name string
+		code func(env *testEnv, code string) string
+		want error
+	}{
+		{"pending", func(env *testEnv, code string) string {
+			return wrongCode(code)
+		}, pkg.ErrOTPPending},
+		{"expired", func(env *testEnv, code string) string {
+			env.otp.Expire(testPhone)
+			return code
+		}, pkg.ErrOTPExpired},
+		{"max attempts", func(env *testEnv, code string) string {
+			for i := 0; i < env.otp.MaxAttempts; i++ {
+				env.otp.VerifyOTP(testPhone, wrongCode(code))
+			}
+			return code
+		}, pkg.ErrOTPMaxAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			code := tt.code(env, env.sendCode(t, testPhone))
+			tokens, isNew, err := env.svc.LoginPhoneOtp(testPhone, code, Device{})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if tokens != (TokenPair{}) || isNew {
+				t.Errorf("tokens issued for a code that was not approved: %+v", tokens)
+			}
+			if _, err := env.users.ReadByPhoneNumber(testPhone); err != pkg.ErrUserNotFound {
+				t.Errorf("user created for a code that was not approved: %v", err)
+			}
+			if len(env.tokens.tokens) != 0 || len(env.sessions.sessions) != 0 {
+				t.Error("session started for a code that was not approved")
+			}
+		})
+	}
+}
+
+// resetUser stores a user with a phone number and an active session and returns it with the session's
+// tokens.
+func resetUser(t *testing.T, env *testEnv) (User, TokenPair) {
+	t.Helper()
+	user := env.addUser(t, User{Name: "Asha", PhoneNumber: testPhone, PhoneVerified: true}, "old-pass-4821")
+	tokens, err := env.svc.Login(testPhone, "old-pass-4821", Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, tokens
+}
+
+func TestPasswordReset(t *testing.T) {
+	env := newTestEnv(t)
+	user, old := resetUser(t, env)
+	if err := env.svc.ForgotPassword("9876543210"); err != nil {
+		t.Fatal(err)
+	}
+	code, ok := env.otp.Code(testPhone)
+	if !ok {
+		t.Fatal("no code sent")
+	}
+	resetToken, err := env.svc.VerifyPasswordReset(testPhone, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.svc.ResetPassword(resetToken, "new-pass-9137"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.svc.Login(testPhone, "old-pass-4821", Device{}); err != pkg.ErrInvalidCredentials {
+		t.Errorf("login with the old password: got %v", err)
+	}
+	if _, err := env.svc.Login(testPhone, "new-pass-9137", Device{}); err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+	if _, err := env.svc.Refresh(old.RefreshToken, Device{}); err != pkg.ErrInvalidRefreshToken {
+		t.Errorf("refresh token issued before the reset: got %v", err)
+	}
+	for _, sess := range env.sessions.sessions {
+		if sess.ID == env.accessClaims(t, old.AccessToken).FamilyID {
+			t.Error("session started before the reset was not ended")
+		}
+	}
+	if err := env.svc.ResetPassword(resetToken, "other-pass-5512"); err != pkg.ErrInvalidGrant {
+		t.Errorf("reset token used twice: got %v", err)
+	}
+	if got := env.user(t, user.ID); env.svc.hasher.Verify(got.Password, "new-pass-9137") != nil {
+		t.Error("password replaced by the second reset")
+	}
+}
+
+func TestPasswordResetRejected(t *testing.T) {
+	env := newTestEnv(t)
+	user, _ := resetUser(t, env)
+	code := env.sendCode(t, testPhone)
+	if _, err := env.svc.VerifyPasswordReset(testPhone, wrongCode(code)); err != pkg.ErrOTPPending {
+		t.Errorf("wrong code: got %v", err)
+	}
+	resetToken, err := env.svc.VerifyPasswordReset(testPhone, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.svc.VerifyPasswordReset(testPhone, code); err != pkg.ErrOTPExpired {
+		t.Errorf("code used twice: got %v", err)
+	}
+
+	if err := env.svc.ResetPassword(resetToken, "short"); !errors.Is(err, pkg.ErrWeakPassword) {
+		t.Errorf("weak password: got %v", err)
+	}
+	if err := env.svc.ResetPassword(resetToken, "asha-pass-7710"); !errors.Is(err, pkg.ErrWeakPassword) {
+		t.Errorf("password containing the name: got %v", err)
+	}
+	if got := env.user(t, user.ID); env.svc.hasher.Verify(got.Password, "old-pass-4821") != nil {
+		t.Error("password replaced by a rejected reset")
+	}
+	if err := env.svc.ResetPassword(resetToken, "new-pass-9137"); err != nil {
+		t.Errorf("reset token used up by a rejected password: %v", err)
+	}
+	if err := env.svc.ResetPassword("not-a-token", "new-pass-9137"); err != pkg.ErrInvalidGrant {
+		t.Errorf("malformed token: got %v", err)
+	}
+}
+
+func TestForgotPasswordUnknownNumber(t *testing.T) {
+	env := newTestEnv(t)
+	if err := env.svc.ForgotPassword(testPhone); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := env.otp.Code(testPhone); ok {
+		t.Error("code sent to a number without an account")
+	}
+}
+
+func TestLogoutAllRevokesTokensOfTheSameSecond(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.addUser(t, User{Name: "Asha", PhoneNumber: testPhone}, "asha-pass-2231")
+	first, err := env.svc.Login(testPhone, "asha-pass-2231", Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := env.svc.Login(testPhone, "asha-pass-2231", Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.svc.LogoutAll(env.accessClaims(t, first.AccessToken)); err != nil {
+		t.Fatal(err)
+	}
+	// The tokens were issued well within the second the cut-off is truncated to.
+	for _, tokens := range []TokenPair{first, second} {
+		claims := env.accessClaims(t, tokens.AccessToken)
+		if revoked, err := env.svc.IsRevoked(claims); err != nil || !revoked {
+			t.Errorf("access token issued before logout all still valid: revoked %v, err %v", revoked, err)
+		}
+		if _, err := env.svc.Refresh(tokens.RefreshToken, Device{}); err != pkg.ErrInvalidRefreshToken {
+			t.Errorf("refresh token issued before logout all: got %v", err)
+		}
+	}
+	if sessions, _ := env.svc.ListSessions(user.ID); len(sessions) != 0 {
+		t.Errorf("%d sessions left", len(sessions))
+	}
+	after, err := env.svc.Login(testPhone, "asha-pass-2231", Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := env.svc.IsRevoked(env.accessClaims(t, after.AccessToken)); revoked {
+		t.Error("access token issued after logout all revoked")
+	}
+}
+
+func TestRefreshUpdatesLastSeen(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.addUser(t, User{Name: "Asha", PhoneNumber: testPhone}, "asha-pass-2231")
+	tokens, err := env.svc.Login(testPhone, "asha-pass-2231", Device{UserAgent: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, _ := env.svc.ListSessions(user.ID)
+	if len(sessions) != 1 {
+		t.Fatalf("%d sessions, want 1", len(sessions))
+	}
+	login := sessions[0]
+	time.Sleep(time.Millisecond)
+	if _, err := env.svc.Refresh(tokens.RefreshToken, Device{UserAgent: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	sessions, _ = env.svc.ListSessions(user.ID)
+	if got := sessions[0]; !got.LastSeenAt.After(login.LastSeenAt) || got.UserAgent != "second" || !got.CreatedAt.Equal(login.CreatedAt) {
+		t.Errorf("session after refresh %+v, at login %+v", got, login)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.addUser(t, User{Name: "Asha", Email: "asha@example.com", Username: "asha"}, "asha-pass-2231")
+	if _, err := env.svc.Login("nobody@example.com", "asha-pass-2231", Device{}); err != pkg.ErrInvalidCredentials {
+		t.Errorf("unknown identifier: got %v", err)
+	}
+	for i := 0; i < env.svc.config.LockoutThreshold; i++ {
+		if _, err := env.svc.Login("asha", "wrong-pass", Device{}); err != pkg.ErrInvalidCredentials {
+			t.Fatalf("wrong password %d: got %v", i+1, err)
+		}
+	}
+	locked := env.user(t, user.ID)
+	if !time.Now().Before(locked.LockedUntil) {
+		t.Fatal("account not locked after reaching the threshold")
+	}
+
+	if _, err := env.svc.Login("ASHA@example.com", "wrong-pass", Device{}); err != pkg.ErrInvalidCredentials {
+		t.Errorf("wrong password on a locked account: got %v", err)
+	}
+	if got := env.user(t, user.ID); got.FailedLogins != locked.FailedLogins || !got.LockedUntil.Equal(locked.LockedUntil) {
+		t.Error("attempt on a locked account extended the lock")
+	}
+	_, err := env.svc.Login("asha", "asha-pass-2231", Device{})
+	var lockErr *pkg.AccountLockedError
+	if !errors.As(err, &lockErr) {
+		t.Fatalf("correct password on a locked account: got %v", err)
+	}
+	if !lockErr.Until.Equal(locked.LockedUntil) {
+		t.Errorf("locked until %v, want %v", lockErr.Until, locked.LockedUntil)
+	}
+	if len(env.tokens.tokens) != 0 {
+		t.Error("tokens issued for a locked account")
+	}
+
+	if err := env.svc.UnlockUser(AccessClaims{UserID: "admin", Role: RoleAdmin}, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.svc.Login("asha", "asha-pass-2231", Device{}); err != nil {
+		t.Fatalf("login after unlock: %v", err)
+	}
+}
+
+func TestLoginWithUsernameThatLooksLikeAnotherIdentifier(t *testing.T) {
+	env := newTestEnv(t)
+	// "12345678" cannot be read as a phone number, "9876543211" can, and "asha@gym" looks like an
+	// email address.
+	for _, username := range []string{"12345678", "9876543211", "asha@gym"} {
+		env.addUser(t, User{Name: "Asha", Username: username}, "asha-pass-2231")
+		if _, err := env.svc.Login(username, "asha-pass-2231", Device{}); err != nil {
+			t.Errorf("login as %q: %v", username, err)
+		}
+	}
+	phoneUser := env.addUser(t, User{Name: "Ravi", PhoneNumber: testPhone}, "ravi-pass-7710")
+	env.addUser(t, User{Name: "Meera", Username: "9876543210"}, "meera-pass-4452")
+	tokens, err := env.svc.Login("9876543210", "ravi-pass-7710", Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims := env.accessClaims(t, tokens.AccessToken); claims.UserID != phoneUser.ID {
+		t.Error("a username took precedence over the phone number it looks like")
+	}
+}
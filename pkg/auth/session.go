@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"sharir/pkg"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The Device type describes the client a login or refresh came from.
+// @property {string} Name - A name the client chose for the device, such as "Pixel 8".
+// @property {string} UserAgent - The `User-Agent` header of the request.
+// @property {string} IP - The IP address of the client.
+type Device struct {
+	Name      string
+	UserAgent string
+	IP        string
+}
+
+// The function starts a new session for the user on the given device and issues its first tokens.
+// Every login ends here, so this is where suspended users are turned away.
+func (s *Svc) startSession(user User, device Device) (TokenPair, error) {
+	if user.Suspended {
+		return TokenPair{}, pkg.ErrAccountSuspended
+	}
+	now := time.Now()
+	sess := Session{
+		ID:         uuid.New().String(),
+		UserID:     user.ID,
+		DeviceName: device.Name,
+		UserAgent:  device.UserAgent,
+		IP:         device.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.config.RefreshTokenTTL),
+	}
+	if err := s.sessions.Create(sess); err != nil {
+		return TokenPair{}, err
+	}
+	return s.issueTokens(user, sess.ID)
+}
+
+// The `ListSessions` function returns the sessions of the user, most recently seen first. A session is
+// seen when it logs in or refreshes its tokens, see `Session.LastSeenAt`.
+func (s *Svc) ListSessions(userID string) ([]Session, error) {
+	return s.sessions.ListByUser(userID)
+}
+
+// The `RevokeSession` function signs the user out of one of their sessions. The session's refresh
+// tokens stop working at once and so do the access tokens that were issued in it.
+func (s *Svc) RevokeSession(userID string, sessionID string) error {
+	sess, err := s.sessions.Read(sessionID)
+	if err == mongo.ErrNoDocuments || (err == nil && sess.UserID != userID) {
+		return pkg.ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.endSession(userID, sessionID)
+}
+
+// The function ends a session by revoking its refresh tokens and access tokens and removing it.
+func (s *Svc) endSession(userID string, sessionID string) error {
+	if err := s.tokens.RevokeFamily(sessionID); err != nil {
+		return err
+	}
+	if err := s.revocations.RevokeFamily(sessionID, userID, time.Now().Add(s.config.AccessTokenTTL)); err != nil {
+		return err
+	}
+	return s.sessions.Delete(sessionID)
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The Session type is the document stored in the `sessions` collection for every login. A session is
+// the refresh token family the login started, so it lives as long as its refresh tokens do.
+// @property {string} ID - The refresh token family ID, which is also the `fid` claim of the session's
+// access tokens.
+// @property {string} UserID - The ID of the user that logged in.
+// @property {string} DeviceName - The name the client gave the device, if any.
+// @property {string} UserAgent - The user agent of the last login or refresh of the session.
+// @property {string} IP - The client IP address of the last login or refresh of the session.
+// @property CreatedAt - The time of the login.
+// @property LastSeenAt - The time tokens were last issued for the session, at login or on a refresh.
+// Requests made with an access token are not recorded, so a session that is in use may have been
+// seen up to one access token lifetime later than this.
+// @property ExpiresAt - The time the last refresh token of the session expires. MongoDB removes the
+// document through a TTL index once this time has passed.
+type Session struct {
+	ID         string    `bson:"_id" json:"id"`
+	UserID     string    `bson:"user_id" json:"-"`
+	DeviceName string    `bson:"device_name" json:"device_name"`
+	UserAgent  string    `bson:"user_agent" json:"user_agent"`
+	IP         string    `bson:"ip" json:"ip"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	LastSeenAt time.Time `bson:"last_seen_at" json:"last_seen_at"`
+	ExpiresAt  time.Time `bson:"expires_at" json:"-"`
+}
+
+// SessionRepository defines the operations that can be performed on stored sessions.
+type SessionRepository interface {
+	Create(sess Session) error
+	Read(id string) (Session, error)
+	ListByUser(userID string) ([]Session, error)
+	Touch(id string, device Device, at time.Time, expiresAt time.Time) error
+	Delete(id string) error
+	DeleteUser(userID string) error
+	EnsureIndexes() error
+}
+
+// SessionRepo is the struct that implements the SessionRepository interface on top of the `sessions`
+// collection. To create a SessionRepo, use the NewSessionRepo function.
+type SessionRepo struct {
+	db      *mongo.Collection
+	context context.Context
+}
+
+// The function stores a new session.
+func (s *SessionRepo) Create(sess Session) error {
+	_, err := s.db.InsertOne(s.context, sess)
+	return err
+}
+
+// The function returns the session with the given ID.
+func (s *SessionRepo) Read(id string) (Session, error) {
+	var sess Session
+	err := s.db.FindOne(s.context, bson.M{"_id": id}).Decode(&sess)
+	return sess, err
+}
+
+// The function returns the sessions of the given user, most recently used first.
+func (s *SessionRepo) ListByUser(userID string) ([]Session, error) {
+	cur, err := s.db.Find(s.context, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"last_seen_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	if err := cur.All(s.context, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// The function records that the session was used from the given device and extends its expiry. The
+// device name is only replaced if the client sent one.
+func (s *SessionRepo) Touch(id string, device Device, at time.Time, expiresAt time.Time) error {
+	set := bson.M{"user_agent": device.UserAgent, "ip": device.IP, "last_seen_at": at, "expires_at": expiresAt}
+	if device.Name != "" {
+		set["device_name"] = device.Name
+	}
+	_, err := s.db.UpdateOne(s.context, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
+
+// The function removes the session with the given ID.
+func (s *SessionRepo) Delete(id string) error {
+	_, err := s.db.DeleteOne(s.context, bson.M{"_id": id})
+	return err
+}
+
+// The function removes every session of the given user.
+func (s *SessionRepo) DeleteUser(userID string) error {
+	_, err := s.db.DeleteMany(s.context, bson.M{"user_id": userID})
+	return err
+}
+
+// The function creates the TTL index that removes expired sessions and the index on the user ID used
+// to list them.
+func (s *SessionRepo) EnsureIndexes() error {
+	_, err := s.db.Indexes().CreateMany(s.context, []mongo.IndexModel{
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.M{"user_id": 1}},
+	})
+	return err
+}
+
+// The function returns a new instance of a SessionRepository interface implementation with a MongoDB
+// database connection.
+func NewSessionRepo(db *mongo.Database) SessionRepository {
+	ctx := context.TODO()
+	return &SessionRepo{db: db.Collection("sessions"), context: ctx}
+}
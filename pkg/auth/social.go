@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sharir/pkg"
+	"sharir/pkg/oidc"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The `BeginSocialLogin` function starts a login with the named OpenID Connect provider and returns
+// the URL to send the user to. The PKCE verifier and nonce stay on the server, keyed by the state.
+func (s *Svc) BeginSocialLogin(ctx context.Context, provider string) (string, error) {
+	client, ok := s.oidc[provider]
+	if !ok {
+		return "", pkg.ErrUnknownProvider
+	}
+	state, err := oidc.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := oidc.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := oidc.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	err = s.oidcRepo.CreateState(OIDCState{
+		ID:        hashToken(state),
+		Provider:  provider,
+		Verifier:  verifier,
+		Nonce:     nonce,
+		ExpiresAt: time.Now().Add(s.config.OIDCStateTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return client.AuthCodeURL(ctx, state, nonce, oidc.CodeChallenge(verifier))
+}
+
+// The `FinishSocialLogin` function completes a login with an OpenID Connect provider. The provider
+// account is looked up among the linked accounts first. An unknown account is linked to the user with
+// the same verified email address or phone number, and if there is none a new user is created, which
+// is reported through the returned flag. Existing users are only linked when they have verified the
+// address themselves, so nobody can claim an address ahead of its owner. Users with TOTP enabled get a
+// `*pkg.MFARequiredError` like a password login.
+func (s *Svc) FinishSocialLogin(ctx context.Context, provider string, state string, code string, device Device) (TokenPair, bool, error) {
+	client, ok := s.oidc[provider]
+	if !ok {
+		return TokenPair{}, false, pkg.ErrUnknownProvider
+	}
+	st, err := s.oidcRepo.ConsumeState(hashToken(state))
+	if err != nil || st.Provider != provider {
+		return TokenPair{}, false, pkg.ErrInvalidGrant
+	}
+	claims, err := client.Exchange(ctx, code, st.Verifier, st.Nonce)
+	if err != nil {
+		return TokenPair{}, false, fmt.Errorf("%w: %v", pkg.ErrSocialLoginFailed, err)
+	}
+	user, created, err := s.socialUser(provider, claims)
+	if err != nil {
+		return TokenPair{}, false, err
+	}
+	if user.TOTPEnabled {
+		return TokenPair{}, false, s.mfaChallenge(user)
+	}
+	tokens, err := s.startSession(user, device)
+	return tokens, created, err
+}
+
+// The function returns the user a provider account belongs to, linking or creating one as described
+// on `FinishSocialLogin`.
+func (s *Svc) socialUser(provider string, claims oidc.Claims) (User, bool, error) {
+	if claims.PhoneNumber != "" {
+		// Providers send E.164 numbers, but one that cannot be read is dropped rather than stored as
+		// it is.
+		normalized, err := s.normalizePhone(claims.PhoneNumber)
+		if err != nil {
+			normalized = ""
+			claims.PhoneVerified = false
+		}
+		claims.PhoneNumber = normalized
+	}
+	identity, err := s.oidcRepo.ReadIdentity(provider, claims.Subject)
+	if err == nil {
+		user, err := s.repo.Read(identity.UserID)
+		return user, false, err
+	}
+	if err != mongo.ErrNoDocuments {
+		return User{}, false, err
+	}
+
+	var user User
+	created := false
+	err = pkg.ErrUserNotFound
+	if claims.EmailVerified && claims.Email != "" {
+		user, err = s.repo.ReadByEmail(claims.Email)
+		if err == nil && !user.EmailVerified {
+			return User{}, false, pkg.ErrSocialAccountConflict
+		}
+	}
+	if err == pkg.ErrUserNotFound && claims.PhoneVerified && claims.PhoneNumber != "" {
+		user, err = s.repo.ReadByPhoneNumber(claims.PhoneNumber)
+		if err == nil && !user.PhoneVerified {
+			return User{}, false, pkg.ErrSocialAccountConflict
+		}
+	}
+	if err == pkg.ErrUserNotFound {
+		user, err = s.repo.Insert(newSocialUser(claims))
+		created = true
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+	err = s.oidcRepo.CreateIdentity(OIDCIdentity{
+		UserID:    user.ID,
+		Provider:  provider,
+		Subject:   claims.Subject,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return User{}, false, err
+	}
+	return user, created, nil
+}
+
+// The function builds a new user from the claims of a provider account. Only addresses the provider
+// has verified are taken over.
+func newSocialUser(claims oidc.Claims) User {
+	user := User{
+		ID:        uuid.New().String(),
+		Name:      claims.Name,
+		UserType:  RoleClient,
+		CreatedAt: time.Now(),
+	}
+	if claims.EmailVerified {
+		user.Email = claims.Email
+		user.EmailVerified = claims.Email != ""
+	}
+	if claims.PhoneVerified {
+		user.PhoneNumber = claims.PhoneNumber
+		user.PhoneVerified = claims.PhoneNumber != ""
+	}
+	return user
+}
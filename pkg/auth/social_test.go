@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sharir/pkg"
+	"sharir/pkg/configuration"
+	"sharir/pkg/oidc/oidctest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newSocialEnv returns a service with the mock issuer configured as the provider "test".
+func newSocialEnv(t *testing.T) (*testEnv, *oidctest.Issuer) {
+	t.Helper()
+	iss := oidctest.NewIssuer(t, "sharir-test")
+	config := testConfig()
+	config.OIDCStateTTL = time.Minute
+	config.OIDCProviders = []configuration.OIDCProvider{{
+		Name:        "test",
+		Issuer:      iss.URL,
+		ClientID:    iss.ClientID,
+		RedirectURL: "https://api.example.com/api/auth/oidc/test/callback",
+		Scopes:      []string{"openid", "email"},
+	}}
+	return newTestEnvWithConfig(t, config), iss
+}
+
+// socialLogin logs in at the issuer as the account with the given claims and finishes the login.
+func socialLogin(t *testing.T, env *testEnv, iss *oidctest.Issuer, claims map[string]interface{}) (TokenPair, bool, error) {
+	t.Helper()
+	ctx := context.Background()
+	authURL, err := env.svc.BeginSocialLogin(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state, err := iss.Authorize(authURL, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env.svc.FinishSocialLogin(ctx, "test", state, code, Device{})
+}
+
+func TestSocialLoginCreatesAndFindsUser(t *testing.T) {
+	env, iss := newSocialEnv(t)
+	claims := map[string]interface{}{"sub": "acct-1", "email": "asha@example.com", "email_verified": true, "name": "Asha"}
+	tokens, created, err := socialLogin(t, env, iss, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("no new user reported for an unknown account")
+	}
+	user, err := env.users.ReadByEmail("asha@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.EmailVerified || env.accessClaims(t, tokens.AccessToken).UserID != user.ID {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if _, created, err = socialLogin(t, env, iss, claims); err != nil || created {
+		t.Fatalf("second login: created %v, err %v", created, err)
+	}
+}
+
+func TestSocialLoginLinksVerifiedEmail(t *testing.T) {
+	env, iss := newSocialEnv(t)
+	user := env.addUser(t, User{Name: "Asha", Email: "asha@example.com", EmailVerified: true}, "")
+	tokens, created, err := socialLogin(t, env, iss, map[string]interface{}{"sub": "acct-1", "email": "Asha@example.com", "email_verified": true})
+	if err != nil || created {
+		t.Fatalf("created %v, err %v", created, err)
+	}
+	if env.accessClaims(t, tokens.AccessToken).UserID != user.ID {
+		t.Error("account not linked to the user with the email address")
+	}
+}
+
+func TestSocialLoginRejected(t *testing.T) {
+	t.Run("unverified address of an existing user", func(t *testing.T) {
+		env, iss := newSocialEnv(t)
+		env.addUser(t, User{Name: "Asha", Email: "asha@example.com"}, "")
+		if _, _, err := socialLogin(t, env, iss, map[string]interface{}{"sub": "acct-1", "email": "asha@example.com", "email_verified": true}); err != pkg.ErrSocialAccountConflict {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("state used twice", func(t *testing.T) {
+		env, iss := newSocialEnv(t)
+		ctx := context.Background()
+		authURL, err := env.svc.BeginSocialLogin(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state, err := iss.Authorize(authURL, map[string]interface{}{"sub": "acct-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := env.svc.FinishSocialLogin(ctx, "test", state, code, Device{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := env.svc.FinishSocialLogin(ctx, "test", state, code, Device{}); err != pkg.ErrInvalidGrant {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("forged id token", func(t *testing.T) {
+		env, iss := newSocialEnv(t)
+		iss.Tamper = func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }
+		if _, _, err := socialLogin(t, env, iss, map[string]interface{}{"sub": "acct-1"}); !errors.Is(err, pkg.ErrSocialLoginFailed) {
+			t.Fatalf("got %v", err)
+		}
+		if len(env.users.docs) != 0 {
+			t.Error("user created from a rejected id token")
+		}
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		env, _ := newSocialEnv(t)
+		if _, err := env.svc.BeginSocialLogin(context.Background(), "other"); err != pkg.ErrUnknownProvider {
+			t.Fatalf("got %v", err)
+		}
+	})
+}
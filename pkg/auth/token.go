@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// The TokenPair type is what every successful login, sign up or refresh hands back to the client.
+// @property {string} AccessToken - A short-lived HS256 JWT that is sent as the bearer token on
+// protected routes.
+// @property {string} RefreshToken - A long-lived opaque token that can be exchanged exactly once for a
+// new TokenPair at `/api/auth/refresh`.
+// @property {int64} ExpiresIn - The lifetime of the access token in seconds.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// The RefreshToken type is the document stored in the `refresh_tokens` collection for every refresh
+// token that is issued. Only the SHA-256 hash of the token is persisted.
+// @property {string} ID - The hex encoded SHA-256 hash of the opaque refresh token.
+// @property {string} UserID - The ID of the user the token was issued to.
+// @property {string} FamilyID - All refresh tokens produced by rotating a single login share the same
+// family. Reusing a rotated token revokes the whole family.
+// @property {bool} Used - Set once the token has been exchanged for a new pair.
+// @property {bool} Revoked - Set when the family has been revoked.
+// @property ExpiresAt - The time after which the token is no longer accepted. MongoDB removes the
+// document through a TTL index once this time has passed.
+// @property CreatedAt - The time the token was issued.
+type RefreshToken struct {
+	ID        string    `bson:"_id"`
+	UserID    string    `bson:"user_id"`
+	FamilyID  string    `bson:"family_id"`
+	Used      bool      `bson:"used"`
+	Revoked   bool      `bson:"revoked"`
+	ExpiresAt time.Time `bson:"expires_at"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// The AccessClaims type holds the claims of a verified access token that the service needs to check
+// and revoke it.
+// @property {string} UserID - The `userid` claim.
+// @property {string} Email - The `email` claim.
+// @property {string} ID - The `jti` claim, a unique ID that every access token carries.
+// @property {string} FamilyID - The `fid` claim, the refresh token family the access token was issued
+// in.
+// @property {string} Purpose - The `purpose` claim. It is only set on grants and is empty on access
+// tokens.
+// @property {string} Role - The `role` claim, the role of the user when the token was issued.
+// @property {string} Challenge - The `challenge` claim of passkey ceremony grants.
+// @property {string} APIKeyID - The ID of the API key a request was authenticated with. It is only set
+// on the claims `AuthenticateAPIKey` returns, which have no user.
+// @property {[]string} Scopes - The scopes of the API key.
+// @property IssuedAt - The `iat` claim.
+// @property ExpiresAt - The `exp` claim.
+type AccessClaims struct {
+	UserID    string
+	Email     string
+	ID        string
+	FamilyID  string
+	Purpose   string
+	Role      string
+	Challenge string
+	APIKeyID  string
+	Scopes    []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// The function reads the AccessClaims out of the claims of a verified access token. Missing claims are
+// left empty.
+func ClaimsFromMap(claims jwt.MapClaims) AccessClaims {
+	var out AccessClaims
+	out.UserID, _ = claims["userid"].(string)
+	out.Email, _ = claims["email"].(string)
+	out.ID, _ = claims["jti"].(string)
+	out.FamilyID, _ = claims["fid"].(string)
+	out.Purpose, _ = claims["purpose"].(string)
+	out.Role, _ = claims["role"].(string)
+	out.Challenge, _ = claims["challenge"].(string)
+	if iat, ok := claims["iat"].(float64); ok {
+		out.IssuedAt = time.Unix(int64(iat), 0)
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		out.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	return out
+}
+
+// The function signs a short-lived access token for the given user with the user's ID, email, a unique
+// token ID, the refresh token family, the user's role, issue time and expiration time.
+func (s *Svc) signAccessToken(user User, familyID string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"userid": user.ID,
+		"email":  user.Email,
+		"jti":    uuid.New().String(),
+		"fid":    familyID,
+		"role":   roleOf(user),
+		"iat":    now.Unix(),
+		"exp":    now.Add(s.config.AccessTokenTTL).Unix(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(s.config.JwtSecret))
+}
+
+// The function issues a new access token and a new refresh token for the given user. The refresh token
+// is stored in the given family, which is the session the tokens belong to. New sessions are started
+// through `startSession`.
+func (s *Svc) issueTokens(user User, familyID string) (TokenPair, error) {
+	access, err := s.signAccessToken(user, familyID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refresh, err := newOpaqueToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	now := time.Now()
+	err = s.tokens.Create(RefreshToken{
+		ID:        hashToken(refresh),
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		ExpiresAt: now.Add(s.config.RefreshTokenTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(s.config.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// The function returns 32 random bytes encoded as an unpadded URL-safe base64 string. It is used for
+// opaque tokens that are only ever compared by hash.
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// The function returns the hex encoded SHA-256 hash of an opaque token, which is the form the token is
+// stored in.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
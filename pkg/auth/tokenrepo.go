@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TokenRepository defines the operations that can be performed on stored refresh tokens.
+type TokenRepository interface {
+	Create(rt RefreshToken) error
+	MarkUsed(id string) (RefreshToken, error)
+	RevokeFamily(familyID string) error
+	RevokeUser(userID string) error
+	EnsureIndexes() error
+}
+
+// TokenRepo is the struct that implements the TokenRepository interface on top of the
+// `refresh_tokens` collection. To create a TokenRepo, use the NewTokenRepo function.
+type TokenRepo struct {
+	db      *mongo.Collection
+	context context.Context
+}
+
+// The function stores a newly issued refresh token.
+func (s *TokenRepo) Create(rt RefreshToken) error {
+	_, err := s.db.InsertOne(s.context, rt)
+	return err
+}
+
+// The function atomically flags the refresh token with the given hash as used and returns the document
+// as it was before the update. A returned token that already has `Used` set means the token has been
+// presented before.
+func (s *TokenRepo) MarkUsed(id string) (RefreshToken, error) {
+	var rt RefreshToken
+	err := s.db.FindOneAndUpdate(s.context, bson.M{"_id": id}, bson.M{"$set": bson.M{"used": true}}).Decode(&rt)
+	return rt, err
+}
+
+// The function revokes every refresh token that belongs to the given family.
+func (s *TokenRepo) RevokeFamily(familyID string) error {
+	_, err := s.db.UpdateMany(s.context, bson.M{"family_id": familyID}, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+// The function revokes every refresh token that was issued to the given user.
+func (s *TokenRepo) RevokeUser(userID string) error {
+	_, err := s.db.UpdateMany(s.context, bson.M{"user_id": userID}, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+// The function creates the indexes the collection relies on: a TTL index that lets MongoDB remove
+// expired tokens and indexes on the family ID and user ID used for revocation.
+func (s *TokenRepo) EnsureIndexes() error {
+	_, err := s.db.Indexes().CreateMany(s.context, []mongo.IndexModel{
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.M{"family_id": 1}},
+		{Keys: bson.M{"user_id": 1}},
+	})
+	return err
+}
+
+// The function returns a new instance of a TokenRepository interface implementation with a MongoDB
+// database connection.
+func NewTokenRepo(db *mongo.Database) TokenRepository {
+	ctx := context.TODO()
+	return &TokenRepo{db: db.Collection("refresh_tokens"), context: ctx}
+}
@@ -2,8 +2,13 @@ package configuration
 
 // `import "os"` is importing the `os` package, which provides a way to interact with the operating
 // system. In this specific code, it is used to retrieve environment variables using the `os.Getenv()`
-// function.
-import "os"
+// function. The `time` package is used to parse durations such as token lifetimes.
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // `var config Config` is declaring a variable named `config` of type `Config`. This variable will be
 // used to store the configuration values retrieved from environment variables.
@@ -19,19 +24,345 @@ var config Config
 // secret key used for JSON Web Token (JWT) authentication. JWT is a popular method for securely
 // transmitting information between parties as a JSON object. The secret key is used to sign and verify
 // the authenticity of the token.
+// @property AccessTokenTTL - AccessTokenTTL is how long an access token stays valid after it is
+// issued. It is read from `ACCESS_TOKEN_TTL` and defaults to 15 minutes.
+// @property RefreshTokenTTL - RefreshTokenTTL is how long a refresh token stays valid after it is
+// issued. It is read from `REFRESH_TOKEN_TTL` and defaults to 30 days.
+// @property {string} OTPProvider - OTPProvider selects how phone OTPs are sent and verified. It is
+// read from `OTP_PROVIDER` and is either "twilio" (the default), "local" for the self-hosted engine,
+// or "fake", which only logs the codes.
+// @property {string} TwilioAccountSID - The Twilio account SID, read from `TWILIO_ACCOUNT_SID`.
+// @property {string} TwilioAuthToken - The Twilio auth token, read from `TWILIO_AUTHTOKEN`.
+// @property {string} TwilioServiceID - The SID of the Twilio Verify service, read from
+// `TWILIO_SERVICES_ID`.
+// @property {string} TwilioFromNumber - The number the self-hosted engine sends text messages from,
+// read from `TWILIO_FROM_NUMBER`.
+// @property {string} SMSTransport - SMSTransport selects how the self-hosted engine delivers codes. It
+// is read from `SMS_TRANSPORT` and is either "twilio" (the default) or "log".
+// @property {int} OTPLength - The number of digits of a self-hosted code, read from `OTP_LENGTH`.
+// @property OTPTTL - How long a self-hosted code stays valid, read from `OTP_TTL`.
+// @property {int} OTPMaxAttempts - How many verify attempts a self-hosted code allows, read from
+// `OTP_MAX_ATTEMPTS`.
+// @property OTPResendCooldown - How long to wait before another code can be sent to the same number,
+// read from `OTP_RESEND_COOLDOWN`.
+// @property {string} RateLimitStore - RateLimitStore selects where rate limit counters are kept. It is
+// read from `RATE_LIMIT_STORE` and is either "memory" (the default) or "mongo", which must be used when
+// more than one instance of the API is running.
+// @property RateLimits - The rate limits of the OTP and login endpoints.
+// @property {int} LockoutThreshold - The number of consecutive failed logins after which an account is
+// locked, read from `LOCKOUT_THRESHOLD`.
+// @property LockoutBaseDuration - How long the first lock lasts, read from `LOCKOUT_BASE_DURATION`.
+// Every further failed login doubles it.
+// @property LockoutMaxDuration - The longest a lock can last, read from `LOCKOUT_MAX_DURATION`.
+// @property PasswordResetTTL - How long a password reset token stays valid after the OTP was verified,
+// read from `PASSWORD_RESET_TTL`.
+// @property {int} PasswordMinLength - The minimum length of a new password, read from
+// `PASSWORD_MIN_LENGTH`.
+// @property {int} PasswordMaxLength - The maximum length of a new password in bytes, read from
+// `PASSWORD_MAX_LENGTH`. It defaults to 72, the most bcrypt can hash.
+// @property {bool} PasswordRequireMixed - Whether a new password needs both letters and digits, read
+// from `PASSWORD_REQUIRE_MIXED`.
+// @property {int} PasswordMinEntropy - The minimum estimated entropy of a new password in bits, read
+// from `PASSWORD_MIN_ENTROPY`. 0 disables the check.
+// @property {string} PasswordBreachedList - The breached password list new passwords are checked
+// against, read from `PASSWORD_BREACHED_LIST`. It is either a file with one SHA-1 hash per line or a
+// directory of k-anonymity range files named by the first five hex digits of the hash. Empty disables
+// the check.
+// @property {string} PasswordHashAlgorithm - The algorithm new password hashes are made with, read
+// from `PASSWORD_HASH_ALGORITHM`. It is either "bcrypt" (the default) or "argon2id". Stored hashes of
+// the other algorithm keep working and are replaced on the next login.
+// @property {int} BcryptCost - The bcrypt cost, read from `BCRYPT_COST`.
+// @property {int} Argon2Time - The number of argon2id passes, read from `ARGON2_TIME`.
+// @property {int} Argon2Memory - The memory argon2id uses in KiB, read from `ARGON2_MEMORY`.
+// @property {int} Argon2Threads - The number of argon2id lanes, read from `ARGON2_THREADS`.
+// @property {string} MailTransport - MailTransport selects how emails are delivered. It is read from
+// `MAIL_TRANSPORT` and is either "capture" (the default), which only logs the messages, or "smtp".
+// @property {string} SMTPHost - The SMTP server host, read from `SMTP_HOST`.
+// @property {string} SMTPPort - The SMTP server port, read from `SMTP_PORT`.
+// @property {string} SMTPUsername - The SMTP username, read from `SMTP_USERNAME`.
+// @property {string} SMTPPassword - The SMTP password, read from `SMTP_PASSWORD`.
+// @property {string} MailFrom - The address emails are sent from, read from `MAIL_FROM`.
+// @property {string} AppBaseURL - The public URL of the API that links in emails point to, read from
+// `APP_BASE_URL`.
+// @property EmailVerificationTTL - How long an email verification link stays valid, read from
+// `EMAIL_VERIFICATION_TTL`.
+// @property MagicLinkTTL - How long a magic login link stays valid, read from `MAGIC_LINK_TTL`.
+// @property {string} MagicLinkURL - The page of the client app that magic login links open, read from
+// `MAGIC_LINK_URL`. The page gets the link's token in the `token` query parameter and redeems it
+// with `/api/auth/magic-link/redeem`. It defaults to `/magic-link` under `APP_BASE_URL`.
+// @property {string} TOTPIssuer - The service name authenticator apps show next to TOTP codes, read
+// from `TOTP_ISSUER`.
+// @property MFAChallengeTTL - How long a login may take to provide the second factor, read from
+// `MFA_CHALLENGE_TTL`.
+// @property {string} WebAuthnRPID - The domain passkeys are registered for, read from `WEBAUTHN_RP_ID`.
+// @property {string} WebAuthnRPName - The name authenticators show for passkeys, read from
+// `WEBAUTHN_RP_NAME`.
+// @property {[]string} WebAuthnOrigins - The origins passkey ceremonies may come from, read as a comma
+// separated list from `WEBAUTHN_ORIGINS`.
+// @property WebAuthnTimeout - How long a passkey ceremony may take, read from `WEBAUTHN_TIMEOUT`.
+// @property OIDCProviders - The identity providers offered for social login. Their names are read as
+// a comma separated list from `OIDC_PROVIDERS` and the settings of each from `OIDC_<NAME>_*`.
+// @property OIDCStateTTL - How long a social login may take, read from `OIDC_STATE_TTL`.
+// @property {string} PhoneDefaultRegion - The ISO 3166 country code phone numbers without a country
+// code are read in, read from `PHONE_DEFAULT_REGION`. It defaults to "IN".
 type Config struct {
-	MongoURI  string
-	Port      string
-	JwtSecret string
+	MongoURI              string
+	Port                  string
+	JwtSecret             string
+	AccessTokenTTL        time.Duration
+	RefreshTokenTTL       time.Duration
+	OTPProvider           string
+	TwilioAccountSID      string
+	TwilioAuthToken       string
+	TwilioServiceID       string
+	TwilioFromNumber      string
+	SMSTransport          string
+	OTPLength             int
+	OTPTTL                time.Duration
+	OTPMaxAttempts        int
+	OTPResendCooldown     time.Duration
+	RateLimitStore        string
+	RateLimits            RateLimits
+	LockoutThreshold      int
+	LockoutBaseDuration   time.Duration
+	LockoutMaxDuration    time.Duration
+	PasswordResetTTL      time.Duration
+	PasswordMinLength     int
+	PasswordMaxLength     int
+	PasswordRequireMixed  bool
+	PasswordMinEntropy    int
+	PasswordBreachedList  string
+	PasswordHashAlgorithm string
+	BcryptCost            int
+	Argon2Time            int
+	Argon2Memory          int
+	Argon2Threads         int
+	MailTransport         string
+	SMTPHost              string
+	SMTPPort              string
+	SMTPUsername          string
+	SMTPPassword          string
+	MailFrom              string
+	AppBaseURL            string
+	EmailVerificationTTL  time.Duration
+	MagicLinkTTL          time.Duration
+	MagicLinkURL          string
+	TOTPIssuer            string
+	MFAChallengeTTL       time.Duration
+	WebAuthnRPID          string
+	WebAuthnRPName        string
+	WebAuthnOrigins       []string
+	WebAuthnTimeout       time.Duration
+	OIDCProviders         []OIDCProvider
+	OIDCStateTTL          time.Duration
+	PhoneDefaultRegion    string
+}
+
+// The OIDCProvider type holds the settings of an OpenID Connect identity provider. For a provider
+// named "google" they are read from `OIDC_GOOGLE_ISSUER`, `OIDC_GOOGLE_CLIENT_ID` and so on.
+// @property {string} Name - The lower case name used in the login routes.
+// @property {string} Issuer - The issuer URL, read from `_ISSUER`. Google and Apple default to their
+// public issuers.
+// @property {string} ClientID - The client ID, read from `_CLIENT_ID`.
+// @property {string} ClientSecret - The client secret, read from `_CLIENT_SECRET`.
+// @property {string} RedirectURL - The callback URL registered with the provider, read from
+// `_REDIRECT_URL`. It defaults to the callback route under `APP_BASE_URL`.
+// @property {[]string} Scopes - The scopes to request, read as a comma separated list from `_SCOPES`.
+// @property {string} ResponseMode - The response mode to request, read from `_RESPONSE_MODE`. Apple
+// defaults to "form_post".
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	ResponseMode string
+}
+
+// The RateLimits type holds the rate limits of the endpoints that are throttled.
+// @property SendOTP - The limits of `/api/auth/sendotp`, read from `RATE_LIMIT_SEND_OTP_*`.
+// @property VerifyOTP - The limits of `/api/auth/verifyotp`, read from `RATE_LIMIT_VERIFY_OTP_*`.
+// @property Login - The limits of `/api/auth/login`, read from `RATE_LIMIT_LOGIN_*`.
+// @property MagicLink - The limits of `/api/auth/magic-link`, read from `RATE_LIMIT_MAGIC_LINK_*`.
+type RateLimits struct {
+	SendOTP   LimitSet
+	VerifyOTP LimitSet
+	Login     LimitSet
+	MagicLink LimitSet
+}
+
+// The LimitSet type holds the limits of a single endpoint. Every limit is written as
+// "<limit>/<window>", for example "5/10m"; "0" disables it.
+// @property {string} PerPhone - The limit per phone number, read from the `_PER_PHONE` variable. On
+// endpoints that take an email address or a username it is the limit per identifier instead.
+// @property {string} PerIP - The limit per client IP address, read from the `_PER_IP` variable.
+// @property {string} Global - The limit across all clients, read from the `_GLOBAL` variable.
+type LimitSet struct {
+	PerPhone string
+	PerIP    string
+	Global   string
 }
 
 // The function retrieves configuration values from environment variables and returns them as a Config
 // struct.
 func FromEnv() Config {
 	config := Config{
-		MongoURI:  os.Getenv("MONGO_URI"),
-		Port:      os.Getenv("PORT"),
-		JwtSecret: os.Getenv("JWT_SECRET"),
+		MongoURI:          os.Getenv("MONGO_URI"),
+		Port:              os.Getenv("PORT"),
+		JwtSecret:         os.Getenv("JWT_SECRET"),
+		AccessTokenTTL:    durationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:   durationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		OTPProvider:       stringFromEnv("OTP_PROVIDER", "twilio"),
+		TwilioAccountSID:  os.Getenv("TWILIO_ACCOUNT_SID"),
+		TwilioAuthToken:   os.Getenv("TWILIO_AUTHTOKEN"),
+		TwilioServiceID:   os.Getenv("TWILIO_SERVICES_ID"),
+		TwilioFromNumber:  os.Getenv("TWILIO_FROM_NUMBER"),
+		SMSTransport:      stringFromEnv("SMS_TRANSPORT", "twilio"),
+		OTPLength:         intFromEnv("OTP_LENGTH", 6),
+		OTPTTL:            durationFromEnv("OTP_TTL", 10*time.Minute),
+		OTPMaxAttempts:    intFromEnv("OTP_MAX_ATTEMPTS", 5),
+		OTPResendCooldown: durationFromEnv("OTP_RESEND_COOLDOWN", 30*time.Second),
+		RateLimitStore:    stringFromEnv("RATE_LIMIT_STORE", "memory"),
+		RateLimits: RateLimits{
+			SendOTP:   limitSetFromEnv("RATE_LIMIT_SEND_OTP", LimitSet{PerPhone: "3/10m", PerIP: "20/1h", Global: "1000/1h"}),
+			VerifyOTP: limitSetFromEnv("RATE_LIMIT_VERIFY_OTP", LimitSet{PerPhone: "10/10m", PerIP: "50/1h", Global: "5000/1h"}),
+			Login:     limitSetFromEnv("RATE_LIMIT_LOGIN", LimitSet{PerPhone: "10/15m", PerIP: "50/15m", Global: "5000/1h"}),
+			MagicLink: limitSetFromEnv("RATE_LIMIT_MAGIC_LINK", LimitSet{PerPhone: "3/15m", PerIP: "20/1h", Global: "1000/1h"}),
+		},
+		LockoutThreshold:      intFromEnv("LOCKOUT_THRESHOLD", 5),
+		LockoutBaseDuration:   durationFromEnv("LOCKOUT_BASE_DURATION", time.Minute),
+		LockoutMaxDuration:    durationFromEnv("LOCKOUT_MAX_DURATION", 24*time.Hour),
+		PasswordResetTTL:      durationFromEnv("PASSWORD_RESET_TTL", 10*time.Minute),
+		PasswordMinLength:     intFromEnv("PASSWORD_MIN_LENGTH", 8),
+		PasswordMaxLength:     intFromEnv("PASSWORD_MAX_LENGTH", 72),
+		PasswordRequireMixed:  boolFromEnv("PASSWORD_REQUIRE_MIXED", true),
+		PasswordMinEntropy:    optionalIntFromEnv("PASSWORD_MIN_ENTROPY", 35),
+		PasswordBreachedList:  os.Getenv("PASSWORD_BREACHED_LIST"),
+		PasswordHashAlgorithm: stringFromEnv("PASSWORD_HASH_ALGORITHM", "bcrypt"),
+		BcryptCost:            intFromEnv("BCRYPT_COST", 12),
+		Argon2Time:            intFromEnv("ARGON2_TIME", 3),
+		Argon2Memory:          intFromEnv("ARGON2_MEMORY", 64*1024),
+		Argon2Threads:         intFromEnv("ARGON2_THREADS", 4),
+		MailTransport:         stringFromEnv("MAIL_TRANSPORT", "capture"),
+		SMTPHost:              os.Getenv("SMTP_HOST"),
+		SMTPPort:              stringFromEnv("SMTP_PORT", "587"),
+		SMTPUsername:          os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:          os.Getenv("SMTP_PASSWORD"),
+		MailFrom:              os.Getenv("MAIL_FROM"),
+		AppBaseURL:            os.Getenv("APP_BASE_URL"),
+		EmailVerificationTTL:  durationFromEnv("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+		MagicLinkTTL:          durationFromEnv("MAGIC_LINK_TTL", 15*time.Minute),
+		MagicLinkURL:          stringFromEnv("MAGIC_LINK_URL", strings.TrimSuffix(os.Getenv("APP_BASE_URL"), "/")+"/magic-link"),
+		TOTPIssuer:            stringFromEnv("TOTP_ISSUER", "Sharir"),
+		MFAChallengeTTL:       durationFromEnv("MFA_CHALLENGE_TTL", 5*time.Minute),
+		WebAuthnRPID:          stringFromEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:        stringFromEnv("WEBAUTHN_RP_NAME", "Sharir"),
+		WebAuthnOrigins:       listFromEnv("WEBAUTHN_ORIGINS", []string{"http://localhost:3000"}),
+		WebAuthnTimeout:       durationFromEnv("WEBAUTHN_TIMEOUT", 5*time.Minute),
+		OIDCProviders:         oidcProvidersFromEnv(os.Getenv("APP_BASE_URL")),
+		OIDCStateTTL:          durationFromEnv("OIDC_STATE_TTL", 10*time.Minute),
+		PhoneDefaultRegion:    strings.ToUpper(stringFromEnv("PHONE_DEFAULT_REGION", "IN")),
 	}
 	return config
 }
+
+// The function returns the value of the environment variable `key`, or the default value `def` if the
+// variable is unset or empty.
+func stringFromEnv(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// The function reads a comma separated list from the environment variable `key`, dropping empty
+// entries. If the variable is unset or empty, the default value `def` is returned instead.
+func listFromEnv(key string, def []string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 0 {
+		return def
+	}
+	return out
+}
+
+// The function reads the identity providers named in `OIDC_PROVIDERS`. Redirect URLs default to the
+// callback route under `baseURL`.
+func oidcProvidersFromEnv(baseURL string) []OIDCProvider {
+	defaults := map[string]OIDCProvider{
+		"google": {Issuer: "https://accounts.google.com"},
+		"apple":  {Issuer: "https://appleid.apple.com", ResponseMode: "form_post"},
+	}
+	var providers []OIDCProvider
+	for _, name := range listFromEnv("OIDC_PROVIDERS", nil) {
+		name = strings.ToLower(name)
+		def := defaults[name]
+		prefix := "OIDC_" + strings.ToUpper(name)
+		providers = append(providers, OIDCProvider{
+			Name:         name,
+			Issuer:       stringFromEnv(prefix+"_ISSUER", def.Issuer),
+			ClientID:     os.Getenv(prefix + "_CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "_CLIENT_SECRET"),
+			RedirectURL:  stringFromEnv(prefix+"_REDIRECT_URL", strings.TrimSuffix(baseURL, "/")+"/api/auth/oidc/"+name+"/callback"),
+			Scopes:       listFromEnv(prefix+"_SCOPES", []string{"openid", "email", "profile"}),
+			ResponseMode: stringFromEnv(prefix+"_RESPONSE_MODE", def.ResponseMode),
+		})
+	}
+	return providers
+}
+
+// The function reads the limits of one endpoint from the `<prefix>_PER_PHONE`, `<prefix>_PER_IP` and
+// `<prefix>_GLOBAL` environment variables, falling back to the limits in `def`.
+func limitSetFromEnv(prefix string, def LimitSet) LimitSet {
+	return LimitSet{
+		PerPhone: stringFromEnv(prefix+"_PER_PHONE", def.PerPhone),
+		PerIP:    stringFromEnv(prefix+"_PER_IP", def.PerIP),
+		Global:   stringFromEnv(prefix+"_GLOBAL", def.Global),
+	}
+}
+
+// The function reads a positive integer from the environment variable `key`. If the variable is unset
+// or cannot be parsed, the default value `def` is returned instead.
+func intFromEnv(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+// The function reads a non-negative integer from the environment variable `key` for settings that 0
+// disables. If the variable is unset, negative or cannot be parsed, the default value `def` is returned
+// instead.
+func optionalIntFromEnv(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+// The function reads a boolean such as "true" or "0" from the environment variable `key`. If the
+// variable is unset or cannot be parsed, the default value `def` is returned instead.
+func boolFromEnv(key string, def bool) bool {
+	b, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// The function reads a duration such as "15m" or "720h" from the environment variable `key`. If the
+// variable is unset or cannot be parsed, the default value `def` is returned instead.
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,26 @@
+package configuration
+
+import "testing"
+
+func TestPasswordMinEntropyFromEnv(t *testing.T) {
+	tests := map[string]int{
+		"":     35,
+		"50":   50,
+		"0":    0,
+		"-1":   35,
+		"lots": 35,
+	}
+	for value, want := range tests {
+		t.Setenv("PASSWORD_MIN_ENTROPY", value)
+		if got := FromEnv().PasswordMinEntropy; got != want {
+			t.Errorf("PASSWORD_MIN_ENTROPY=%q: got %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestIntFromEnvRejectsZero(t *testing.T) {
+	t.Setenv("OTP_MAX_ATTEMPTS", "0")
+	if got := FromEnv().OTPMaxAttempts; got != 5 {
+		t.Errorf("OTP_MAX_ATTEMPTS=0: got %d, want the default", got)
+	}
+}
@@ -1,12 +1,101 @@
 package pkg
 
 // The `import "errors"` statement is importing the `errors` package from the Go standard library. This
-// package provides a simple way to create and manipulate errors in Go programs.
-import "errors"
+// package provides a simple way to create and manipulate errors in Go programs. The `time` package is
+// used by errors that carry a point in time.
+import (
+	"errors"
+	"time"
+)
 
 // Declaring a variable `ErrUserNotFound` and assigning it a new error instance with the message "user
 // not found" using the `errors.New()` function from the `errors` package. This variable can be used to
 // represent the specific error of a user not being found in the program.
+// `ErrInvalidRefreshToken` is returned when a refresh token is unknown, expired or revoked, and
+// `ErrRefreshTokenReused` is returned when an already rotated refresh token is presented again.
+// `ErrTokenRevoked` is returned when an access token has been revoked through logout.
+// `ErrOTPPending` is returned when a one-time password has not been approved, which is what happens
+// when the code does not match the code that was sent.
+// `ErrOTPExpired` is returned when there is no active code for the phone number any more,
+// `ErrOTPMaxAttempts` when too many wrong codes have been entered and `ErrOTPCooldown` when a new code
+// is requested too soon after the previous one.
+// `ErrInvalidGrant` is returned when a single-use token such as a password reset token is malformed,
+// expired or has already been used. `ErrWeakPassword` is wrapped by the errors of the password policy
+// and `ErrIncorrectPassword` is returned when the current password given to change it is wrong.
+// `ErrEmailRequired`, `ErrEmailAlreadyVerified` and `ErrEmailNotVerified` are returned by the email
+// verification flow and by actions that require a verified email.
+// `ErrInvalidMFACode`, `ErrTOTPNotEnrolled` and `ErrTOTPAlreadyEnabled` are returned by two-factor
+// authentication. `ErrInvalidPasskey`, `ErrPasskeyExists` and `ErrPasskeyNotFound` are returned by
+// passkey registration and login. `ErrUnknownProvider`, `ErrSocialLoginFailed` and
+// `ErrSocialAccountConflict` are returned by social login. `ErrSessionNotFound` is returned when a
+// session to sign out of does not exist or belongs to someone else. `ErrInvalidRole` is returned for a
+// role that does not exist and `ErrRoleNotAllowed` when the caller may not grant the role or act on
+// the account. `ErrAccountSuspended` is returned when a suspended user tries to log in.
+// `ErrInvalidAPIKey` is returned for an unknown, revoked or expired API key, `ErrInvalidScope` for a
+// scope that does not exist, `ErrInvalidAPIKeyRequest` for a key without a name or with an expiry in
+// the past and `ErrAPIKeyNotFound` when a key to revoke does not exist. `ErrPhoneNumberTaken`,
+// `ErrEmailTaken` and `ErrUsernameTaken` are returned when another account already has the value.
+// `ErrInvalidPhoneNumber` is returned for a phone number that cannot be read. `ErrInvalidCredentials`
+// is returned by password logins for every failure that could tell whether the identifier exists.
 var (
-	ErrUserNotFound = errors.New("user not found")
+	ErrUserNotFound          = errors.New("user not found")
+	ErrInvalidRefreshToken   = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused    = errors.New("refresh token reuse detected, session revoked")
+	ErrTokenRevoked          = errors.New("token has been revoked")
+	ErrOTPPending            = errors.New("incorrect otp code, verification is still pending")
+	ErrOTPExpired            = errors.New("otp code has expired")
+	ErrOTPMaxAttempts        = errors.New("maximum otp verification attempts reached")
+	ErrOTPCooldown           = errors.New("please wait before requesting a new otp code")
+	ErrInvalidGrant          = errors.New("invalid or expired token")
+	ErrWeakPassword          = errors.New("password does not meet the password policy")
+	ErrIncorrectPassword     = errors.New("current password is incorrect")
+	ErrEmailRequired         = errors.New("no email address on the account")
+	ErrEmailAlreadyVerified  = errors.New("email address is already verified")
+	ErrEmailNotVerified      = errors.New("a verified email address is required")
+	ErrInvalidMFACode        = errors.New("invalid two-factor code")
+	ErrTOTPNotEnrolled       = errors.New("two-factor authentication is not set up")
+	ErrTOTPAlreadyEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrInvalidPasskey        = errors.New("passkey could not be verified")
+	ErrPasskeyExists         = errors.New("passkey is already registered")
+	ErrPasskeyNotFound       = errors.New("passkey not found")
+	ErrUnknownProvider       = errors.New("unknown identity provider")
+	ErrSocialLoginFailed     = errors.New("login with the identity provider failed")
+	ErrSessionNotFound       = errors.New("session not found")
+	ErrInvalidRole           = errors.New("unknown role")
+	ErrRoleNotAllowed        = errors.New("role can only be granted by an admin")
+	ErrAccountSuspended      = errors.New("account has been suspended")
+	ErrInvalidAPIKey         = errors.New("invalid api key")
+	ErrInvalidScope          = errors.New("unknown api key scope")
+	ErrInvalidAPIKeyRequest  = errors.New("api keys need a name and their expiry must be in the future")
+	ErrAPIKeyNotFound        = errors.New("api key not found")
+	ErrPhoneNumberTaken      = errors.New("phone number is already registered")
+	ErrEmailTaken            = errors.New("email address is already registered")
+	ErrUsernameTaken         = errors.New("username is already taken")
+	ErrInvalidPhoneNumber    = errors.New("invalid phone number")
+	ErrInvalidCredentials    = errors.New("invalid identifier or password")
+	ErrSocialAccountConflict = errors.New("an account with this email address or phone number already exists, log in and verify it to link the provider")
 )
+
+// The AccountLockedError type is returned when a login is attempted on an account that is temporarily
+// locked after too many failed attempts.
+// @property Until - The time the lock is lifted. Clients can show it to the user.
+type AccountLockedError struct {
+	Until time.Time
+}
+
+// The function returns the message of the error, including the time the lock is lifted.
+func (e *AccountLockedError) Error() string {
+	return "account locked until " + e.Until.UTC().Format(time.RFC3339)
+}
+
+// The MFARequiredError type is returned by a password login that succeeded but still needs a second
+// factor because the user has two-factor authentication enabled.
+// @property {string} Token - A short-lived challenge token to send back together with the code.
+type MFARequiredError struct {
+	Token string
+}
+
+// The function returns the message of the error.
+func (e *MFARequiredError) Error() string {
+	return "two-factor authentication required"
+}
@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"log"
+	"sync"
+)
+
+// CaptureMailer is a Mailer that keeps messages in memory and writes them to the log instead of
+// sending them. It is meant for local development and tests.
+// @property mu - `mu` guards `messages`.
+// @property messages - `messages` holds every message sent so far, oldest first.
+type CaptureMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// The function records the message and writes it to the log.
+func (m *CaptureMailer) Send(msg Message) error {
+	m.mu.Lock()
+	m.messages = append(m.messages, msg)
+	m.mu.Unlock()
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// The function returns every message sent so far, oldest first.
+func (m *CaptureMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// The function returns the last message sent to the given address.
+func (m *CaptureMailer) Last(to string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+// The function returns a new CaptureMailer with no messages.
+func NewCaptureMailer() *CaptureMailer {
+	return &CaptureMailer{}
+}
@@ -0,0 +1,18 @@
+package mail
+
+// The Message type is a plain text email.
+// @property {string} To - The address the message is sent to.
+// @property {string} Subject - The subject line.
+// @property {string} Body - The plain text body.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is an interface that defines how emails are delivered, so the services that send mail do not
+// depend on a particular transport.
+// @property Send - Send delivers the message.
+type Mailer interface {
+	Send(msg Message) error
+}
@@ -0,0 +1,282 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// The errors returned by the client. `ErrInvalidIDToken` covers every way an ID token can fail
+// verification; the details are wrapped for logging.
+var (
+	ErrInvalidIDToken = errors.New("oidc: invalid id token")
+	ErrExchange       = errors.New("oidc: code exchange failed")
+)
+
+// The Provider type holds the settings of one identity provider.
+// @property {string} Name - The name the provider is addressed by in routes, such as "google".
+// @property {string} Issuer - The issuer URL. The provider's endpoints are discovered from
+// `<Issuer>/.well-known/openid-configuration`.
+// @property {string} ClientID - The client ID registered with the provider.
+// @property {string} ClientSecret - The client secret. It may be empty for public clients.
+// @property {string} RedirectURL - The callback URL registered with the provider.
+// @property {[]string} Scopes - The scopes to request. "openid" is always requested.
+// @property {string} ResponseMode - The `response_mode` to request, such as "form_post" which Apple
+// requires when asking for the email address. Empty uses the provider's default.
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	ResponseMode string
+}
+
+// The Claims type holds the verified claims of an ID token that are used to find or create a user.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	PhoneNumber   string
+	PhoneVerified bool
+	Name          string
+}
+
+// The Client type runs the authorization code flow with PKCE against one provider. Discovery and the
+// provider's signing keys are fetched on first use and cached.
+type Client struct {
+	provider Provider
+	http     *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]interface{}
+	keysAt    time.Time
+}
+
+// The discovery type holds the parts of the provider metadata the client uses.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// `keysMinAge` is how long fetched signing keys are used before an unknown key ID triggers a refetch.
+const keysMinAge = time.Minute
+
+// The function returns a client for the given provider. If `httpClient` is nil a client with a ten
+// second timeout is used.
+func NewClient(p Provider, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{provider: p, http: httpClient}
+}
+
+// The function returns a random PKCE code verifier, state or nonce.
+func NewRandom() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// The function returns the S256 PKCE code challenge for a code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// The function returns the URL to send the user to in order to log in at the provider.
+func (c *Client) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	d, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	scopes := []string{"openid"}
+	for _, s := range c.provider.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", c.provider.ClientID)
+	q.Set("redirect_uri", c.provider.RedirectURL)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+	if c.provider.ResponseMode != "" {
+		q.Set("response_mode", c.provider.ResponseMode)
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// The function exchanges an authorization code for tokens and returns the claims of the verified ID
+// token. `nonce` must be the nonce the flow was started with.
+func (c *Client) Exchange(ctx context.Context, code string, verifier string, nonce string) (Claims, error) {
+	d, err := c.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.provider.RedirectURL)
+	form.Set("client_id", c.provider.ClientID)
+	form.Set("code_verifier", verifier)
+	if c.provider.ClientSecret != "" {
+		form.Set("client_secret", c.provider.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := c.http.Do(req)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return Claims{}, fmt.Errorf("%w: token endpoint returned %d", ErrExchange, res.StatusCode)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.IDToken == "" {
+		return Claims{}, fmt.Errorf("%w: no id token in response", ErrExchange)
+	}
+	return c.verifyIDToken(ctx, d, tokens.IDToken, nonce)
+}
+
+// The function verifies the signature, issuer, audience, expiry and nonce of an ID token.
+func (c *Client) verifyIDToken(ctx context.Context, d *discovery, raw string, nonce string) (Claims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return c.key(ctx, d, kid)
+	}, jwt.WithValidMethods([]string{"RS256", "ES256"}))
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if !claims.VerifyIssuer(d.Issuer, true) {
+		return Claims{}, fmt.Errorf("%w: unexpected issuer", ErrInvalidIDToken)
+	}
+	if !claims.VerifyAudience(c.provider.ClientID, true) {
+		return Claims{}, fmt.Errorf("%w: unexpected audience", ErrInvalidIDToken)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return Claims{}, fmt.Errorf("%w: missing expiry", ErrInvalidIDToken)
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	var out Claims
+	out.Subject, _ = claims["sub"].(string)
+	out.Email, _ = claims["email"].(string)
+	out.EmailVerified = boolClaim(claims["email_verified"])
+	out.PhoneNumber, _ = claims["phone_number"].(string)
+	out.PhoneVerified = boolClaim(claims["phone_number_verified"])
+	out.Name, _ = claims["name"].(string)
+	if out.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+	return out, nil
+}
+
+// The function reads a boolean claim. Some providers, Apple among them, send booleans as strings.
+func boolClaim(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	default:
+		return false
+	}
+}
+
+// The function returns the provider metadata, fetching it on first use.
+func (c *Client) discover(ctx context.Context) (*discovery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.discovery != nil {
+		return c.discovery, nil
+	}
+	var d discovery
+	if err := c.getJSON(ctx, strings.TrimSuffix(c.provider.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != c.provider.Issuer || d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: invalid discovery document for %s", c.provider.Issuer)
+	}
+	c.discovery = &d
+	return c.discovery, nil
+}
+
+// The function returns the signing key with the given key ID. Keys are refetched when an unknown key
+// ID shows up, which is how providers roll their keys, but at most once every `keysMinAge`.
+func (c *Client) key(ctx context.Context, d *discovery, kid string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(c.keysAt) < keysMinAge {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	var set jwkSet
+	if err := c.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	c.keys = set.publicKeys()
+	c.keysAt = time.Now()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// The function fetches a JSON document.
+func (c *Client) getJSON(ctx context.Context, u string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s returned %d", u, res.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(out)
+}
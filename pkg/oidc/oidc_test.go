@@ -0,0 +1,160 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/url"
+	"sharir/pkg/oidc"
+	"sharir/pkg/oidc/oidctest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const clientID = "sharir-test"
+
+// login runs the authorization code flow against the issuer for a user with the given claims and
+// returns the verified claims of the ID token.
+func login(t *testing.T, iss *oidctest.Issuer, client *oidc.Client, claims map[string]interface{}) (oidc.Claims, error) {
+	t.Helper()
+	ctx := context.Background()
+	verifier, _ := oidc.NewRandom()
+	nonce, _ := oidc.NewRandom()
+	authURL, err := client.AuthCodeURL(ctx, "state-1", nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state, err := iss.Authorize(authURL, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "state-1" {
+		t.Fatalf("state %q not passed through", state)
+	}
+	return client.Exchange(ctx, code, verifier, nonce)
+}
+
+func newClient(iss *oidctest.Issuer) *oidc.Client {
+	return oidc.NewClient(oidc.Provider{
+		Name:        "test",
+		Issuer:      iss.URL,
+		ClientID:    clientID,
+		RedirectURL: "https://api.example.com/api/auth/oidc/test/callback",
+		Scopes:      []string{"openid", "email"},
+	}, nil)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	iss := oidctest.NewIssuer(t, clientID)
+	authURL, err := newClient(iss).AuthCodeURL(context.Background(), "s", "n", oidc.CodeChallenge("v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, iss.URL+"/authorize?") {
+		t.Errorf("authorization endpoint not discovered: %s", authURL)
+	}
+	q := u.Query()
+	if q.Get("scope") != "openid email" || q.Get("code_challenge") != oidc.CodeChallenge("v") || q.Get("nonce") != "n" {
+		t.Errorf("unexpected parameters %v", q)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	iss := oidctest.NewIssuer(t, clientID)
+	got, err := login(t, iss, newClient(iss), map[string]interface{}{
+		"sub":                   "user-1",
+		"email":                 "asha@example.com",
+		"email_verified":        "true",
+		"phone_number":          "+919876543210",
+		"phone_number_verified": true,
+		"name":                  "Asha",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := oidc.Claims{Subject: "user-1", Email: "asha@example.com", EmailVerified: true, PhoneNumber: "+919876543210", PhoneVerified: true, Name: "Asha"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestExchangeRejected(t *testing.T) {
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		tamper func(claims jwt.MapClaims)
+	}{
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{"missing expiry", func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"wrong nonce", func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{"missing subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iss := oidctest.NewIssuer(t, clientID)
+			iss.Tamper = tt.tamper
+			if _, err := login(t, iss, newClient(iss), map[string]interface{}{"sub": "user-1"}); !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Fatalf("got %v, want %v", err, oidc.ErrInvalidIDToken)
+			}
+		})
+	}
+
+	t.Run("unknown signing key", func(t *testing.T) {
+		iss := oidctest.NewIssuer(t, clientID)
+		client := newClient(iss)
+		if _, err := login(t, iss, client, map[string]interface{}{"sub": "user-1"}); err != nil {
+			t.Fatal(err)
+		}
+		// A key the issuer does not publish is not fetched again right after the last fetch.
+		iss.RotateKey()
+		if _, err := login(t, iss, client, map[string]interface{}{"sub": "user-1"}); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Fatalf("got %v, want %v", err, oidc.ErrInvalidIDToken)
+		}
+	})
+
+	t.Run("wrong pkce verifier", func(t *testing.T) {
+		iss := oidctest.NewIssuer(t, clientID)
+		client := newClient(iss)
+		ctx := context.Background()
+		authURL, err := client.AuthCodeURL(ctx, "s", "n", oidc.CodeChallenge("verifier"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _, err := iss.Authorize(authURL, map[string]interface{}{"sub": "user-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Exchange(ctx, code, "another verifier", "n"); !errors.Is(err, oidc.ErrExchange) {
+			t.Fatalf("got %v, want %v", err, oidc.ErrExchange)
+		}
+	})
+
+	t.Run("signed with another key", func(t *testing.T) {
+		iss := oidctest.NewIssuer(t, clientID)
+		iss.SignWith = other
+		if _, err := login(t, iss, newClient(iss), map[string]interface{}{"sub": "user-1"}); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Fatalf("got %v, want %v", err, oidc.ErrInvalidIDToken)
+		}
+	})
+}
+
+func TestInvalidDiscovery(t *testing.T) {
+	iss := oidctest.NewIssuer(t, clientID)
+	client := oidc.NewClient(oidc.Provider{Name: "test", Issuer: iss.URL + "/other", ClientID: clientID}, nil)
+	if _, err := client.AuthCodeURL(context.Background(), "s", "n", "c"); err == nil {
+		t.Fatal("discovery document of another issuer accepted")
+	}
+}
@@ -0,0 +1,182 @@
+// Package oidctest provides an OpenID Connect issuer for tests. It serves discovery, a JWKS and a token
+// endpoint on a local HTTP server and signs ID tokens with an RSA key, so the whole authorization code
+// flow with PKCE can run without a real provider.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// The Issuer type is a running mock identity provider.
+// @property {string} URL - The issuer URL, which is also the base URL of the server.
+// @property {string} ClientID - The only client ID the token endpoint accepts.
+// @property Tamper - If set, it is called with the claims of every ID token before it is signed, so
+// tests can produce tokens a real provider would not.
+// @property SignWith - If set, ID tokens are signed with this key under the published key ID, which is
+// what a forged token looks like.
+type Issuer struct {
+	URL      string
+	ClientID string
+	Tamper   func(claims jwt.MapClaims)
+	SignWith *rsa.PrivateKey
+
+	server *httptest.Server
+	mu     sync.Mutex
+	key    *rsa.PrivateKey
+	kid    string
+	codes  map[string]grant
+}
+
+// The grant type is an authorization code the issuer has handed out.
+type grant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	claims      jwt.MapClaims
+}
+
+// The function starts an issuer that accepts `clientID`. The server is closed when the test ends.
+func NewIssuer(t testing.TB, clientID string) *Issuer {
+	t.Helper()
+	iss := &Issuer{ClientID: clientID, codes: map[string]grant{}}
+	if err := iss.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", iss.discovery)
+	mux.HandleFunc("/jwks", iss.jwks)
+	mux.HandleFunc("/token", iss.token)
+	iss.server = httptest.NewServer(mux)
+	iss.URL = iss.server.URL
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+// The function replaces the signing key with a new one under a new key ID.
+func (iss *Issuer) RotateKey() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.key = key
+	iss.kid = fmt.Sprintf("key-%d", time.Now().UnixNano())
+	return nil
+}
+
+// The function plays the user logging in at the authorization URL the client built. It checks the
+// request, remembers the PKCE challenge and nonce, and returns the code and state the provider would
+// redirect back with. The ID token issued for the code carries `claims`, which must include "sub".
+func (iss *Issuer) Authorize(authURL string, claims map[string]interface{}) (string, string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	q := u.Query()
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		return "", "", fmt.Errorf("oidctest: unsupported authorization request %s", authURL)
+	}
+	if q.Get("client_id") != iss.ClientID {
+		return "", "", fmt.Errorf("oidctest: unknown client %q", q.Get("client_id"))
+	}
+	code := randomString()
+	iss.mu.Lock()
+	iss.codes[code] = grant{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		claims:      jwt.MapClaims(claims),
+	}
+	iss.mu.Unlock()
+	return code, q.Get("state"), nil
+}
+
+func (iss *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{
+		"issuer":                 iss.URL,
+		"authorization_endpoint": iss.URL + "/authorize",
+		"token_endpoint":         iss.URL + "/token",
+		"jwks_uri":               iss.URL + "/jwks",
+	})
+}
+
+func (iss *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	iss.mu.Lock()
+	pub, kid := iss.key.PublicKey, iss.kid
+	iss.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": kid,
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+// The function redeems an authorization code once, checking the client, redirect URI and PKCE
+// verifier like a provider does.
+func (iss *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+	iss.mu.Lock()
+	g, ok := iss.codes[r.PostForm.Get("code")]
+	delete(iss.codes, r.PostForm.Get("code"))
+	key, kid := iss.key, iss.kid
+	iss.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || g.clientID != r.PostForm.Get("client_id") || g.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{"iss": iss.URL, "aud": g.clientID, "nonce": g.nonce, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+	if iss.Tamper != nil {
+		iss.Tamper(claims)
+	}
+	if iss.SignWith != nil {
+		key = iss.SignWith
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]string{"access_token": randomString(), "token_type": "Bearer", "id_token": signed})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,111 @@
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sharir/pkg"
+	"sharir/pkg/configuration"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Engine is a self-hosted OTPProvider. It generates the codes itself, stores a keyed hash of each code
+// in MongoDB and only uses an SMSTransport to deliver the text message, so no per verification fee is
+// paid to a verification service.
+// @property repo - `repo` is the `Repository` that stores the active codes.
+// @property transport - `transport` delivers the text message containing the code.
+// @property config - `config` holds the code length, lifetime, attempt limit, resend cooldown and the
+// secret the codes are hashed with.
+type Engine struct {
+	repo      Repository
+	transport SMSTransport
+	config    configuration.Config
+}
+
+// The function generates a new code for the phone number, stores its hash and sends it through the SMS
+// transport. It returns `pkg.ErrOTPCooldown` if the previous code was sent less than the resend
+// cooldown ago.
+func (e *Engine) SendOTP(phone string) error {
+	code, err := e.generateCode()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = e.repo.Replace(Code{
+		ID:        phone,
+		Hash:      e.hashCode(phone, code),
+		SentAt:    now,
+		ExpiresAt: now.Add(e.config.OTPTTL),
+	}, now.Add(-e.config.OTPResendCooldown))
+	if mongo.IsDuplicateKeyError(err) {
+		return pkg.ErrOTPCooldown
+	}
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Your Sharir verification code is %s. It expires in %d minutes.", code, int(e.config.OTPTTL.Minutes()))
+	if err := e.transport.SendSMS(phone, body); err != nil {
+		e.repo.Delete(phone)
+		return err
+	}
+	return nil
+}
+
+// The function checks the code entered for the phone number. Every call counts as an attempt, and once
+// the attempt limit is reached the code can no longer be used even if it is correct. A correct code is
+// consumed, so it can only be verified once.
+func (e *Engine) VerifyOTP(phone string, code string) error {
+	active, err := e.repo.IncrementAttempts(phone)
+	if err == mongo.ErrNoDocuments {
+		return pkg.ErrOTPExpired
+	}
+	if err != nil {
+		return err
+	}
+	if time.Now().After(active.ExpiresAt) {
+		return pkg.ErrOTPExpired
+	}
+	if active.Attempts > e.config.OTPMaxAttempts {
+		return pkg.ErrOTPMaxAttempts
+	}
+	if !hmac.Equal([]byte(e.hashCode(phone, code)), []byte(active.Hash)) {
+		return pkg.ErrOTPPending
+	}
+	consumed, err := e.repo.Consume(phone, active.Hash)
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return pkg.ErrOTPExpired
+	}
+	return nil
+}
+
+// The function returns a random numeric code with the configured number of digits.
+func (e *Engine) generateCode() (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(e.config.OTPLength)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", e.config.OTPLength, n), nil
+}
+
+// The function returns the hex encoded HMAC-SHA256 of the phone number and the code. Short numeric
+// codes are trivial to brute force from a plain hash, so the hash is keyed with the server secret.
+func (e *Engine) hashCode(phone string, code string) string {
+	mac := hmac.New(sha256.New, []byte(e.config.JwtSecret))
+	mac.Write([]byte(phone + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// The function returns a new self-hosted OTPProvider that stores codes through the given repository
+// and delivers them through the given SMS transport.
+func NewEngine(repo Repository, transport SMSTransport, config configuration.Config) OTPProvider {
+	return &Engine{repo: repo, transport: transport, config: config}
+}
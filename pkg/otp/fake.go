@@ -0,0 +1,97 @@
+package otp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"sharir/pkg"
+	"sync"
+	"time"
+)
+
+// FakeProvider is an in-process OTPProvider for local development and tests. It never sends an SMS.
+// Codes are written to the log and can be read back with the Code method. Codes expire and count
+// verify attempts like the real providers do, so every outcome of a verification can be produced.
+// @property TTL - How long a code stays valid.
+// @property {int} MaxAttempts - The number of verify attempts a code allows.
+// @property mu - `mu` guards `codes`.
+// @property codes - `codes` maps a phone number to the last code that was sent to it.
+type FakeProvider struct {
+	TTL         time.Duration
+	MaxAttempts int
+	mu          sync.Mutex
+	codes       map[string]*fakeCode
+}
+
+// The fakeCode type is a code the fake provider has sent.
+type fakeCode struct {
+	code      string
+	attempts  int
+	expiresAt time.Time
+}
+
+// The function generates a six digit code for the phone number, remembers it and logs it instead of
+// sending it.
+func (p *FakeProvider) SendOTP(phone string) error {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	p.mu.Lock()
+	p.codes[phone] = &fakeCode{code: code, expiresAt: time.Now().Add(p.TTL)}
+	p.mu.Unlock()
+	log.Printf("otp: code for %s is %s", phone, code)
+	return nil
+}
+
+// The function checks the code against the last code sent to the phone number. It returns
+// `pkg.ErrOTPExpired` if no code is active, `pkg.ErrOTPMaxAttempts` once the code has been tried too
+// often and `pkg.ErrOTPPending` for a wrong code. A code can only be used once.
+func (p *FakeProvider) VerifyOTP(phone string, code string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sent, ok := p.codes[phone]
+	if !ok || time.Now().After(sent.expiresAt) {
+		delete(p.codes, phone)
+		return pkg.ErrOTPExpired
+	}
+	sent.attempts++
+	if sent.attempts > p.MaxAttempts {
+		return pkg.ErrOTPMaxAttempts
+	}
+	if sent.code != code {
+		return pkg.ErrOTPPending
+	}
+	delete(p.codes, phone)
+	return nil
+}
+
+// The function returns the last code sent to the phone number that has not been used yet. It lets
+// tests complete the OTP flow without reading the log.
+func (p *FakeProvider) Code(phone string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sent, ok := p.codes[phone]
+	if !ok {
+		return "", false
+	}
+	return sent.code, true
+}
+
+// The function lets the code sent to the phone number expire immediately. It lets tests reach the
+// expired outcome without waiting for the TTL.
+func (p *FakeProvider) Expire(phone string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if sent, ok := p.codes[phone]; ok {
+		sent.expiresAt = time.Now().Add(-time.Second)
+	}
+}
+
+// The function returns a new FakeProvider with no codes sent. Codes are valid for `ttl` and allow
+// `maxAttempts` verify attempts.
+func NewFakeProvider(ttl time.Duration, maxAttempts int) *FakeProvider {
+	return &FakeProvider{TTL: ttl, MaxAttempts: maxAttempts, codes: map[string]*fakeCode{}}
+}
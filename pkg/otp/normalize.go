@@ -0,0 +1,41 @@
+package otp
+
+import (
+	"sharir/pkg"
+	"sharir/pkg/phone"
+)
+
+// The normalizingProvider type is an OTPProvider that hands phone numbers to another provider in E.164
+// form, see `WithPhoneNormalization`.
+// @property provider - The provider codes are sent and verified with.
+// @property {string} region - The region numbers without a country code are read in.
+type normalizingProvider struct {
+	provider OTPProvider
+	region   string
+}
+
+// The function returns an OTPProvider that normalizes every phone number to E.164 before it is passed
+// to `p`, reading numbers without a country code as numbers of `region`. A code sent to "98765 43210"
+// can then be verified as "+919876543210" and the other way round. Numbers that cannot be read return
+// `pkg.ErrInvalidPhoneNumber` without reaching `p`.
+func WithPhoneNormalization(p OTPProvider, region string) OTPProvider {
+	return &normalizingProvider{provider: p, region: region}
+}
+
+// The function sends a code to the normalized phone number.
+func (n *normalizingProvider) SendOTP(raw string) error {
+	normalized, err := phone.Normalize(raw, n.region)
+	if err != nil {
+		return pkg.ErrInvalidPhoneNumber
+	}
+	return n.provider.SendOTP(normalized)
+}
+
+// The function verifies a code for the normalized phone number.
+func (n *normalizingProvider) VerifyOTP(raw string, code string) error {
+	normalized, err := phone.Normalize(raw, n.region)
+	if err != nil {
+		return pkg.ErrInvalidPhoneNumber
+	}
+	return n.provider.VerifyOTP(normalized, code)
+}
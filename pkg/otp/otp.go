@@ -0,0 +1,14 @@
+package otp
+
+// OTPProvider is an interface that defines how one-time passwords are delivered to and checked for a
+// phone number. The phone OTP routes only talk to this interface, so the backing service can be
+// swapped without touching the handlers.
+// @property SendOTP - SendOTP delivers a new one-time password to the given phone number.
+// @property VerifyOTP - VerifyOTP checks the code the user entered for the given phone number. It
+// returns nil only once the code has been approved. A wrong code returns `pkg.ErrOTPPending`, a code
+// that is no longer active returns `pkg.ErrOTPExpired` and a code with too many wrong attempts returns
+// `pkg.ErrOTPMaxAttempts`.
+type OTPProvider interface {
+	SendOTP(phone string) error
+	VerifyOTP(phone string, code string) error
+}
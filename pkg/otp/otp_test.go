@@ -0,0 +1,251 @@
+package otp
+
+import (
+	"errors"
+	"regexp"
+	"sharir/pkg"
+	"sharir/pkg/configuration"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const testPhone = "+919876543210"
+
+// memRepo is an in-memory Repository that behaves like the MongoDB one.
+type memRepo struct {
+	mu    sync.Mutex
+	codes map[string]Code
+}
+
+func newMemRepo() *memRepo {
+	return &memRepo{codes: map[string]Code{}}
+}
+
+func (r *memRepo) Replace(code Code, sentBefore time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev, ok := r.codes[code.ID]; ok && !prev.SentAt.Before(sentBefore) {
+		return mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	}
+	r.codes[code.ID] = code
+	return nil
+}
+
+func (r *memRepo) IncrementAttempts(phone string) (Code, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	code, ok := r.codes[phone]
+	if !ok {
+		return Code{}, mongo.ErrNoDocuments
+	}
+	code.Attempts++
+	r.codes[phone] = code
+	return code, nil
+}
+
+func (r *memRepo) Consume(phone string, hash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	code, ok := r.codes[phone]
+	if !ok || code.Hash != hash {
+		return false, nil
+	}
+	delete(r.codes, phone)
+	return true, nil
+}
+
+func (r *memRepo) Delete(phone string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.codes, phone)
+	return nil
+}
+
+func (r *memRepo) EnsureIndexes() error {
+	return nil
+}
+
+// captureTransport keeps the last message sent to every number.
+type captureTransport struct {
+	sent map[string]string
+}
+
+func (t *captureTransport) SendSMS(to string, body string) error {
+	t.sent[to] = body
+	return nil
+}
+
+var codePattern = regexp.MustCompile(`\d{6}`)
+
+func (t *captureTransport) code(to string) string {
+	return codePattern.FindString(t.sent[to])
+}
+
+func newTestEngine() (*Engine, *memRepo, *captureTransport) {
+	repo := newMemRepo()
+	transport := &captureTransport{sent: map[string]string{}}
+	config := configuration.Config{
+		JwtSecret:         "test-secret",
+		OTPLength:         6,
+		OTPTTL:            10 * time.Minute,
+		OTPMaxAttempts:    3,
+		OTPResendCooldown: 30 * time.Second,
+	}
+	return NewEngine(repo, transport, config).(*Engine), repo, transport
+}
+
+// wrongCode returns a code that differs from `code`.
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestFakeProviderOutcomes(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(p *FakeProvider, code string) error
+		want error
+	}{
+		{"approved", func(p *FakeProvider, code string) error {
+			return p.VerifyOTP(testPhone, code)
+		}, nil},
+		{"pending", func(p *FakeProvider, code string) error {
+			return p.VerifyOTP(testPhone, wrongCode(code))
+		}, pkg.ErrOTPPending},
+		{"expired", func(p *FakeProvider, code string) error {
+			p.Expire(testPhone)
+			return p.VerifyOTP(testPhone, code)
+		}, pkg.ErrOTPExpired},
+		{"never sent", func(p *FakeProvider, code string) error {
+			return p.VerifyOTP("+919000000000", code)
+		}, pkg.ErrOTPExpired},
+		{"max attempts", func(p *FakeProvider, code string) error {
+			for i := 0; i < p.MaxAttempts; i++ {
+				p.VerifyOTP(testPhone, wrongCode(code))
+			}
+			return p.VerifyOTP(testPhone, code)
+		}, pkg.ErrOTPMaxAttempts},
+		{"used twice", func(p *FakeProvider, code string) error {
+			if err := p.VerifyOTP(testPhone, code); err != nil {
+				return err
+			}
+			return p.VerifyOTP(testPhone, code)
+		}, pkg.ErrOTPExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewFakeProvider(time.Minute, 3)
+			if err := p.SendOTP(testPhone); err != nil {
+				t.Fatal(err)
+			}
+			code, ok := p.Code(testPhone)
+			if !ok {
+				t.Fatal("no code recorded")
+			}
+			if err := tt.run(p, code); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineOutcomes(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(e *Engine, repo *memRepo, code string) error
+		want error
+	}{
+		{"approved", func(e *Engine, repo *memRepo, code string) error {
+			return e.VerifyOTP(testPhone, code)
+		}, nil},
+		{"pending", func(e *Engine, repo *memRepo, code string) error {
+			return e.VerifyOTP(testPhone, wrongCode(code))
+		}, pkg.ErrOTPPending},
+		{"expired", func(e *Engine, repo *memRepo, code string) error {
+			c := repo.codes[testPhone]
+			c.ExpiresAt = time.Now().Add(-time.Second)
+			repo.codes[testPhone] = c
+			return e.VerifyOTP(testPhone, code)
+		}, pkg.ErrOTPExpired},
+		{"max attempts", func(e *Engine, repo *memRepo, code string) error {
+			for i := 0; i < e.config.OTPMaxAttempts; i++ {
+				e.VerifyOTP(testPhone, wrongCode(code))
+			}
+			return e.VerifyOTP(testPhone, code)
+		}, pkg.ErrOTPMaxAttempts},
+		{"used twice", func(e *Engine, repo *memRepo, code string) error {
+			if err := e.VerifyOTP(testPhone, code); err != nil {
+				return err
+			}
+			return e.VerifyOTP(testPhone, code)
+		}, pkg.ErrOTPExpired},
+		{"resend cooldown", func(e *Engine, repo *memRepo, code string) error {
+			return e.SendOTP(testPhone)
+		}, pkg.ErrOTPCooldown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, repo, transport := newTestEngine()
+			if err := e.SendOTP(testPhone); err != nil {
+				t.Fatal(err)
+			}
+			code := transport.code(testPhone)
+			if code == "" {
+				t.Fatalf("no code in %q", transport.sent[testPhone])
+			}
+			if repo.codes[testPhone].Hash == code {
+				t.Fatal("code stored in plain text")
+			}
+			if err := tt.run(e, repo, code); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTwilioVerificationStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   error
+	}{
+		{"approved", nil},
+		{"pending", pkg.ErrOTPPending},
+		{"expired", pkg.ErrOTPExpired},
+		{"canceled", pkg.ErrOTPExpired},
+		{"max_attempts_reached", pkg.ErrOTPMaxAttempts},
+	}
+	for _, tt := range tests {
+		if err := verificationStatusError(tt.status); err != tt.want {
+			t.Errorf("status %q: got %v, want %v", tt.status, err, tt.want)
+		}
+	}
+	if err := verificationStatusError("failed"); err == nil {
+		t.Error("unknown status approved the code")
+	}
+}
+
+func TestWithPhoneNormalization(t *testing.T) {
+	fake := NewFakeProvider(time.Minute, 3)
+	p := WithPhoneNormalization(fake, "IN")
+	if err := p.SendOTP("098765-43210"); err != nil {
+		t.Fatal(err)
+	}
+	code, ok := fake.Code(testPhone)
+	if !ok {
+		t.Fatal("code not sent to the E.164 number")
+	}
+	if err := p.VerifyOTP("+91 98765 43210", code); err != nil {
+		t.Fatalf("verifying with another format: %v", err)
+	}
+	if err := p.SendOTP("not a number"); err != pkg.ErrInvalidPhoneNumber {
+		t.Errorf("invalid number: got %v", err)
+	}
+	if err := p.VerifyOTP("12", "000000"); err != pkg.ErrInvalidPhoneNumber {
+		t.Errorf("invalid number: got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package otp
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The Code type is the document stored in the `otp_codes` collection for the code that was last sent
+// to a phone number. Only a keyed hash of the code is persisted.
+// @property {string} ID - The phone number the code was sent to. There is at most one active code per
+// phone number.
+// @property {string} Hash - The hex encoded HMAC-SHA256 of the phone number and the code.
+// @property {int} Attempts - The number of verify attempts made against this code so far.
+// @property SentAt - The time the code was sent. It is used to enforce the resend cooldown.
+// @property ExpiresAt - The time after which the code is no longer accepted. MongoDB removes the
+// document through a TTL index once this time has passed.
+type Code struct {
+	ID        string    `bson:"_id"`
+	Hash      string    `bson:"hash"`
+	Attempts  int       `bson:"attempts"`
+	SentAt    time.Time `bson:"sent_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Repository defines the operations that can be performed on stored OTP codes.
+type Repository interface {
+	Replace(code Code, sentBefore time.Time) error
+	IncrementAttempts(phone string) (Code, error)
+	Consume(phone string, hash string) (bool, error)
+	Delete(phone string) error
+	EnsureIndexes() error
+}
+
+// Repo is the struct that implements the Repository interface on top of the `otp_codes` collection.
+// To create a Repo, use the NewRepo function.
+type Repo struct {
+	db      *mongo.Collection
+	context context.Context
+}
+
+// The function stores `code` as the active code of its phone number, replacing the previous code only
+// if that one was sent before `sentBefore`. If the previous code is more recent, the upsert collides
+// with the existing document and a duplicate key error is returned, which the engine reports as a
+// resend cooldown.
+func (s *Repo) Replace(code Code, sentBefore time.Time) error {
+	_, err := s.db.ReplaceOne(s.context,
+		bson.M{"_id": code.ID, "sent_at": bson.M{"$lt": sentBefore}},
+		code,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// The function increments the number of verify attempts of the active code of a phone number and
+// returns the code as it is after the update.
+func (s *Repo) IncrementAttempts(phone string) (Code, error) {
+	var code Code
+	err := s.db.FindOneAndUpdate(s.context,
+		bson.M{"_id": phone},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&code)
+	return code, err
+}
+
+// The function removes the active code of a phone number if it still has the given hash. It reports
+// whether the code was removed, which makes every code usable exactly once even under concurrent
+// verify requests.
+func (s *Repo) Consume(phone string, hash string) (bool, error) {
+	res, err := s.db.DeleteOne(s.context, bson.M{"_id": phone, "hash": hash})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+// The function removes the active code of a phone number.
+func (s *Repo) Delete(phone string) error {
+	_, err := s.db.DeleteOne(s.context, bson.M{"_id": phone})
+	return err
+}
+
+// The function creates the TTL index that lets MongoDB remove expired codes.
+func (s *Repo) EnsureIndexes() error {
+	_, err := s.db.Indexes().CreateOne(s.context, mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// The function returns a new instance of a Repository interface implementation with a MongoDB database
+// connection.
+func NewRepo(db *mongo.Database) Repository {
+	ctx := context.TODO()
+	return &Repo{db: db.Collection("otp_codes"), context: ctx}
+}
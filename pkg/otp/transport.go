@@ -0,0 +1,54 @@
+package otp
+
+import (
+	"log"
+
+	"github.com/twilio/twilio-go"
+	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// SMSTransport is an interface that defines how the self-hosted OTP engine delivers a text message.
+// @property SendSMS - SendSMS sends the message `body` to the phone number `to`.
+type SMSTransport interface {
+	SendSMS(to string, body string) error
+}
+
+// TwilioSMSTransport is the SMSTransport implementation backed by the Twilio Messaging API. Unlike
+// Twilio Verify it is billed per message only.
+// @property client - `client` is the Twilio REST client used to send messages.
+// @property from - `from` is the Twilio phone number or messaging service the messages are sent from.
+type TwilioSMSTransport struct {
+	client *twilio.RestClient
+	from   string
+}
+
+// The function sends a text message through the Twilio Messaging API.
+func (t *TwilioSMSTransport) SendSMS(to string, body string) error {
+	params := &twilioApi.CreateMessageParams{}
+	params.SetTo(to)
+	params.SetFrom(t.from)
+	params.SetBody(body)
+
+	_, err := t.client.Api.CreateMessage(params)
+	return err
+}
+
+// The function returns a new SMSTransport that sends messages from the given number through Twilio,
+// authenticating with the given account SID and auth token.
+func NewTwilioSMSTransport(accountSID string, authToken string, from string) SMSTransport {
+	client := twilio.NewRestClientWithParams(twilio.ClientParams{
+		Username: accountSID,
+		Password: authToken,
+	})
+	return &TwilioSMSTransport{client: client, from: from}
+}
+
+// LogTransport is an SMSTransport that writes messages to the log instead of sending them. It is meant
+// for local development.
+type LogTransport struct{}
+
+// The function writes the message to the log.
+func (LogTransport) SendSMS(to string, body string) error {
+	log.Printf("sms to %s: %s", to, body)
+	return nil
+}
@@ -0,0 +1,92 @@
+package otp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sharir/pkg"
+
+	"github.com/twilio/twilio-go"
+	"github.com/twilio/twilio-go/client"
+	twilioApi "github.com/twilio/twilio-go/rest/verify/v2"
+)
+
+// TwilioProvider is the OTPProvider implementation backed by Twilio Verify.
+// @property client - `client` is the Twilio REST client used to call the Verify API.
+// @property serviceID - `serviceID` is the SID of the Twilio Verify service codes are sent through.
+type TwilioProvider struct {
+	client    *twilio.RestClient
+	serviceID string
+}
+
+// The function sends an OTP (one-time password) to a phone number using Twilio's API.
+func (p *TwilioProvider) SendOTP(phone string) error {
+	params := &twilioApi.CreateVerificationParams{}
+	params.SetTo(phone)
+	params.SetChannel("sms")
+
+	_, err := p.client.VerifyV2.CreateVerification(p.serviceID, params)
+	return err
+}
+
+// The function verifies an OTP code sent to a phone number using Twilio API. It only returns nil when
+// Twilio reports the verification as approved; every other outcome is mapped to an error.
+func (p *TwilioProvider) VerifyOTP(phone string, code string) error {
+	params := &twilioApi.CreateVerificationCheckParams{}
+	params.SetTo(phone)
+	params.SetCode(code)
+
+	resp, err := p.client.VerifyV2.CreateVerificationCheck(p.serviceID, params)
+	if err != nil {
+		return twilioError(err)
+	}
+	if resp.Status == nil {
+		return pkg.ErrOTPPending
+	}
+	return verificationStatusError(*resp.Status)
+}
+
+// The function maps the status of a Twilio verification check to the error the OTP provider returns
+// for it. Only "approved" maps to nil.
+func verificationStatusError(status string) error {
+	switch status {
+	case "approved":
+		return nil
+	case "pending":
+		return pkg.ErrOTPPending
+	case "expired", "canceled":
+		return pkg.ErrOTPExpired
+	case "max_attempts_reached":
+		return pkg.ErrOTPMaxAttempts
+	default:
+		return fmt.Errorf("unexpected otp verification status %q", status)
+	}
+}
+
+// The function maps the errors Twilio returns for a verification check to OTP errors. Twilio answers
+// with a 404 once a verification has expired, been approved or was never started, and with error 60202
+// once the maximum number of check attempts has been reached.
+func twilioError(err error) error {
+	var restErr *client.TwilioRestError
+	if !errors.As(err, &restErr) {
+		return err
+	}
+	switch {
+	case restErr.Status == http.StatusNotFound:
+		return pkg.ErrOTPExpired
+	case restErr.Code == 60202:
+		return pkg.ErrOTPMaxAttempts
+	default:
+		return err
+	}
+}
+
+// The function returns a new OTPProvider that sends codes through the Twilio Verify service with the
+// given SID, authenticating with the given account SID and auth token.
+func NewTwilioProvider(accountSID string, authToken string, serviceID string) OTPProvider {
+	client := twilio.NewRestClientWithParams(twilio.ClientParams{
+		Username: accountSID,
+		Password: authToken,
+	})
+	return &TwilioProvider{client: client, serviceID: serviceID}
+}
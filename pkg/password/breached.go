@@ -0,0 +1,95 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachList tells whether a password is known to have appeared in a data breach.
+type BreachList interface {
+	Contains(password string) (bool, error)
+}
+
+// The rangeDir type is a breach list kept as a directory of k-anonymity range files, the layout the
+// Have I Been Pwned downloader produces: the file `<PREFIX>.txt` holds the hashes starting with the
+// five hex digit prefix, one `<SUFFIX>:<COUNT>` line per hash. Only the one file a password's prefix
+// points to is read on every check, so the full list never has to fit in memory.
+type rangeDir struct {
+	dir string
+}
+
+// The hashSet type is a breach list loaded into memory from a single file with one SHA-1 hash per
+// line, optionally followed by `:<COUNT>`. It suits curated lists of the most common passwords.
+type hashSet map[string]struct{}
+
+// The function returns the upper case hex encoded SHA-1 hash of the password.
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// The function reports whether the password's hash is in the range file for its prefix. A missing
+// range file means no hash with that prefix is known.
+func (d rangeDir) Contains(password string) (bool, error) {
+	hash := sha1Hex(password)
+	f, err := os.Open(filepath.Join(d.dir, hash[:5]+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		suffix, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(suffix, hash[5:]) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// The function reports whether the password's hash is in the set.
+func (s hashSet) Contains(password string) (bool, error) {
+	_, ok := s[sha1Hex(password)]
+	return ok, nil
+}
+
+// The function opens the breach list at `path`. A directory is read as k-anonymity range files and a
+// file as a list of full SHA-1 hashes. Lines that are not hashes, such as comments, are skipped.
+func LoadBreachList(path string) (BreachList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return rangeDir{dir: path}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	set := hashSet{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if len(hash) != sha1.Size*2 {
+			continue
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			continue
+		}
+		set[strings.ToUpper(hash)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
@@ -0,0 +1,184 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"sharir/pkg/configuration"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The hash algorithms passwords can be stored with.
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// The salt and key lengths of argon2id hashes in bytes.
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// `ErrMismatch` is returned when a password does not match the stored hash. Accounts without a
+// password and hashes that cannot be parsed fail the same way.
+var ErrMismatch = errors.New("password does not match")
+
+// Hasher hashes new passwords with the configured algorithm and checks passwords against stored
+// hashes of any supported algorithm. Every hash carries the parameters it was made with, so hashes
+// made under an older configuration keep working and can be told apart from current ones.
+// @property {string} Algorithm - The algorithm new hashes are made with, "bcrypt" or "argon2id".
+// @property {int} BcryptCost - The bcrypt cost.
+// @property {uint32} Argon2Time - The number of argon2id passes over the memory.
+// @property {uint32} Argon2Memory - The memory argon2id uses in KiB.
+// @property {uint8} Argon2Threads - The number of argon2id lanes.
+type Hasher struct {
+	Algorithm     string
+	BcryptCost    int
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+// The argon2Params type holds the parameters encoded in an argon2id hash.
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// The function returns a hash of the password made with the configured algorithm.
+func (h *Hasher) Hash(password string) (string, error) {
+	switch h.Algorithm {
+	case AlgorithmBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	case AlgorithmArgon2id:
+		salt := make([]byte, argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, h.Argon2Time, h.Argon2Memory, h.Argon2Threads, argon2KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Argon2Memory, h.Argon2Time,
+			h.Argon2Threads, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("password: unknown hash algorithm %q", h.Algorithm)
+	}
+}
+
+// The function checks the password against a stored hash. It returns `ErrMismatch` if the password is
+// wrong.
+func (h *Hasher) Verify(hash string, password string) error {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		p, err := parseArgon2(hash)
+		if err != nil {
+			return ErrMismatch
+		}
+		key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+		if subtle.ConstantTimeCompare(key, p.key) != 1 {
+			return ErrMismatch
+		}
+		return nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// The function spends about as long as `Verify` takes on a hash made with the configured parameters,
+// without checking anything. Logins for identifiers that have no password hash call it so that the
+// response time does not tell them apart from wrong passwords.
+func (h *Hasher) Burn(password string) {
+	h.Hash(password)
+}
+
+// The function reports whether a stored hash should be replaced with a new one: it was made with
+// another algorithm or with parameters weaker than the configured ones.
+func (h *Hasher) NeedsRehash(hash string) bool {
+	switch h.Algorithm {
+	case AlgorithmBcrypt:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < h.BcryptCost
+	case AlgorithmArgon2id:
+		p, err := parseArgon2(hash)
+		return err != nil || p.time < h.Argon2Time || p.memory < h.Argon2Memory || p.threads < h.Argon2Threads ||
+			len(p.key) < argon2KeyLength
+	default:
+		return false
+	}
+}
+
+// The function parses a hash in the PHC string format, for example
+// `$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>`.
+func parseArgon2(hash string) (argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return argon2Params{}, errors.New("password: malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Params{}, errors.New("password: unsupported argon2id version")
+	}
+	var p argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return argon2Params{}, errors.New("password: malformed argon2id parameters")
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2Params{}, err
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return argon2Params{}, errors.New("password: malformed argon2id key")
+	}
+	if p.time == 0 || p.threads == 0 {
+		return argon2Params{}, errors.New("password: malformed argon2id parameters")
+	}
+	return p, nil
+}
+
+// The largest amount of memory argon2id may be configured to use, in KiB.
+const argon2MaxMemory = 4 * 1024 * 1024
+
+// The function returns the hasher described by the configuration. An unknown algorithm or parameters
+// out of the range the algorithm supports are an error, so that a typo stops the server at startup
+// instead of failing every sign up and password change.
+func NewHasher(config configuration.Config) (*Hasher, error) {
+	algorithm := strings.ToLower(strings.TrimSpace(config.PasswordHashAlgorithm))
+	switch algorithm {
+	case AlgorithmBcrypt:
+		if config.BcryptCost < bcrypt.MinCost || config.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("password: BCRYPT_COST must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, config.BcryptCost)
+		}
+	case AlgorithmArgon2id:
+		if config.Argon2Time < 1 || int64(config.Argon2Time) > math.MaxUint32 {
+			return nil, fmt.Errorf("password: ARGON2_TIME must be at least 1, got %d", config.Argon2Time)
+		}
+		if config.Argon2Threads < 1 || config.Argon2Threads > math.MaxUint8 {
+			return nil, fmt.Errorf("password: ARGON2_THREADS must be between 1 and %d, got %d", math.MaxUint8, config.Argon2Threads)
+		}
+		if config.Argon2Memory < 8*config.Argon2Threads || config.Argon2Memory > argon2MaxMemory {
+			return nil, fmt.Errorf("password: ARGON2_MEMORY must be between %d and %d KiB, got %d", 8*config.Argon2Threads, argon2MaxMemory, config.Argon2Memory)
+		}
+	default:
+		return nil, fmt.Errorf("password: unknown PASSWORD_HASH_ALGORITHM %q", config.PasswordHashAlgorithm)
+	}
+	return &Hasher{
+		Algorithm:     algorithm,
+		BcryptCost:    config.BcryptCost,
+		Argon2Time:    uint32(config.Argon2Time),
+		Argon2Memory:  uint32(config.Argon2Memory),
+		Argon2Threads: uint8(config.Argon2Threads),
+	}, nil
+}
@@ -0,0 +1,52 @@
+package password
+
+import (
+	"sharir/pkg/configuration"
+	"testing"
+)
+
+func TestNewHasherRejectsInvalidConfiguration(t *testing.T) {
+	valid := configuration.Config{
+		PasswordHashAlgorithm: AlgorithmBcrypt,
+		BcryptCost:            10,
+		Argon2Time:            3,
+		Argon2Memory:          64 * 1024,
+		Argon2Threads:         4,
+	}
+	invalid := map[string]func(*configuration.Config){
+		"unknown algorithm": func(c *configuration.Config) { c.PasswordHashAlgorithm = "bcyrpt" },
+		"bcrypt cost low":   func(c *configuration.Config) { c.BcryptCost = 3 },
+		"bcrypt cost high":  func(c *configuration.Config) { c.BcryptCost = 32 },
+		"argon2 time": func(c *configuration.Config) {
+			c.PasswordHashAlgorithm, c.Argon2Time = AlgorithmArgon2id, 0
+		},
+		"argon2 threads": func(c *configuration.Config) {
+			c.PasswordHashAlgorithm, c.Argon2Threads = AlgorithmArgon2id, 256
+		},
+		"argon2 memory": func(c *configuration.Config) {
+			c.PasswordHashAlgorithm, c.Argon2Memory = AlgorithmArgon2id, 16
+		},
+	}
+	for name, change := range invalid {
+		config := valid
+		change(&config)
+		if _, err := NewHasher(config); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	for _, algorithm := range []string{AlgorithmBcrypt, " Argon2id "} {
+		config := valid
+		config.PasswordHashAlgorithm = algorithm
+		hasher, err := NewHasher(config)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		hash, err := hasher.Hash("correct horse")
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if err := hasher.Verify(hash, "correct horse"); err != nil {
+			t.Errorf("%s: %v", algorithm, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package password
+
+import (
+	"fmt"
+	"math"
+	"sharir/pkg"
+	"sharir/pkg/configuration"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy describes the rules a new password has to satisfy.
+// @property {int} MinLength - The minimum number of characters.
+// @property {int} MaxLength - The maximum number of bytes. bcrypt ignores everything after the 72nd
+// byte, so longer passwords would silently be truncated.
+// @property {bool} RequireMixed - Whether the password needs both a letter and a digit.
+// @property {int} MinEntropy - The minimum estimated entropy in bits, see `Entropy`. 0 disables the
+// check.
+// @property Breached - The list of breached passwords new passwords are checked against. Nil disables
+// the check.
+type Policy struct {
+	MinLength    int
+	MaxLength    int
+	RequireMixed bool
+	MinEntropy   int
+	Breached     BreachList
+}
+
+// The Personal type holds what is known about the user a password is for. Passwords containing any of
+// it are rejected, since it is the first thing an attacker who knows the user would try.
+type Personal struct {
+	Name        string
+	Username    string
+	Email       string
+	PhoneNumber string
+}
+
+// The shortest name, username or email address part that a password may not contain, and the number
+// of trailing phone number digits a password may not contain. Shorter fragments match too many
+// unrelated passwords.
+const (
+	minPersonalLength = 3
+	minPhoneDigits    = 6
+)
+
+// The function checks a new password against the policy. It returns an error wrapping
+// `pkg.ErrWeakPassword` that says which rule was broken.
+func (p *Policy) Check(password string, personal Personal) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters long", pkg.ErrWeakPassword, p.MinLength)
+	}
+	if len(password) > p.MaxLength {
+		return fmt.Errorf("%w: must be at most %d bytes long", pkg.ErrWeakPassword, p.MaxLength)
+	}
+	if p.RequireMixed {
+		var letter, digit bool
+		for _, r := range password {
+			letter = letter || unicode.IsLetter(r)
+			digit = digit || unicode.IsDigit(r)
+		}
+		if !letter || !digit {
+			return fmt.Errorf("%w: must contain both letters and digits", pkg.ErrWeakPassword)
+		}
+	}
+	if containsPersonal(password, personal) {
+		return fmt.Errorf("%w: must not contain your name, username, email address or phone number", pkg.ErrWeakPassword)
+	}
+	if p.MinEntropy > 0 && Entropy(password) < float64(p.MinEntropy) {
+		return fmt.Errorf("%w: is too easy to guess, use a longer or less predictable password", pkg.ErrWeakPassword)
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			return fmt.Errorf("%w: has appeared in a data breach, choose another one", pkg.ErrWeakPassword)
+		}
+	}
+	return nil
+}
+
+// The function returns a rough estimate of the entropy of a password in bits. Every character is worth
+// the bits needed to pick it from the character classes the password uses, except characters that
+// repeat the previous one or continue a sequence such as "abc" or "321", which are worth a single bit.
+func Entropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, c := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if c.used {
+			pool += c.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	perChar := math.Log2(float64(pool))
+	bits := 0.0
+	prev := rune(-1)
+	for _, r := range password {
+		if prev >= 0 && (r == prev || r == prev+1 || r == prev-1) {
+			bits++
+		} else {
+			bits += perChar
+		}
+		prev = r
+	}
+	return bits
+}
+
+// The function reports whether the password contains the user's name or one of its parts, the
+// username, the local part of the email address, or the last digits of the phone number. Letters are
+// compared case-insensitively.
+func containsPersonal(password string, personal Personal) bool {
+	lower := strings.ToLower(password)
+	fragments := strings.Fields(strings.ToLower(personal.Name))
+	fragments = append(fragments, strings.ToLower(personal.Name), strings.ToLower(personal.Username))
+	if local, _, ok := strings.Cut(strings.ToLower(personal.Email), "@"); ok {
+		fragments = append(fragments, local)
+	}
+	for _, f := range fragments {
+		if utf8.RuneCountInString(f) >= minPersonalLength && strings.Contains(lower, f) {
+			return true
+		}
+	}
+	phone := digitsOf(personal.PhoneNumber)
+	if len(phone) >= minPhoneDigits {
+		return strings.Contains(digitsOf(password), phone[len(phone)-minPhoneDigits:])
+	}
+	return false
+}
+
+// The function returns the ASCII digits of `s` in order.
+func digitsOf(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// The function returns the password policy described by the configuration. The breached password
+// list is loaded when `PASSWORD_BREACHED_LIST` is set, and failing to load it is an error.
+func NewPolicy(config configuration.Config) (*Policy, error) {
+	policy := &Policy{
+		MinLength:    config.PasswordMinLength,
+		MaxLength:    config.PasswordMaxLength,
+		RequireMixed: config.PasswordRequireMixed,
+		MinEntropy:   config.PasswordMinEntropy,
+	}
+	if config.PasswordBreachedList != "" {
+		list, err := LoadBreachList(config.PasswordBreachedList)
+		if err != nil {
+			return nil, fmt.Errorf("password: loading breached password list: %w", err)
+		}
+		policy.Breached = list
+	}
+	return policy, nil
+}
@@ -0,0 +1,141 @@
+// Package phone normalizes phone numbers to the E.164 format, such as "+919876543210", so that every
+// way of writing a number maps to the same stored value and the same number is sent to the SMS
+// provider.
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+// `ErrInvalid` is returned for input that is not a phone number of a known length.
+var ErrInvalid = errors.New("phone: invalid phone number")
+
+// `ErrUnknownRegion` is returned when a national number is given for a region missing from the table.
+var ErrUnknownRegion = errors.New("phone: unknown region")
+
+// The region type holds the dialling rules of a country.
+// @property {string} code - The country calling code without the plus sign.
+// @property {string} trunk - The prefix dialled before national numbers inside the country, such as "0".
+// @property {string} intl - The prefix dialled before international numbers, such as "00".
+// @property {int} min - The shortest national number, without the trunk prefix.
+// @property {int} max - The longest national number.
+type region struct {
+	code  string
+	trunk string
+	intl  string
+	min   int
+	max   int
+}
+
+// `regions` holds the dialling rules by ISO 3166 country code.
+var regions = map[string]region{
+	"IN": {code: "91", trunk: "0", intl: "00", min: 10, max: 10},
+	"US": {code: "1", trunk: "1", intl: "011", min: 10, max: 10},
+	"CA": {code: "1", trunk: "1", intl: "011", min: 10, max: 10},
+	"GB": {code: "44", trunk: "0", intl: "00", min: 9, max: 10},
+	"AU": {code: "61", trunk: "0", intl: "0011", min: 9, max: 9},
+	"NZ": {code: "64", trunk: "0", intl: "00", min: 8, max: 10},
+	"AE": {code: "971", trunk: "0", intl: "00", min: 8, max: 9},
+	"SA": {code: "966", trunk: "0", intl: "00", min: 9, max: 9},
+	"SG": {code: "65", intl: "000", min: 8, max: 8},
+	"MY": {code: "60", trunk: "0", intl: "00", min: 9, max: 10},
+	"DE": {code: "49", trunk: "0", intl: "00", min: 6, max: 13},
+	"FR": {code: "33", trunk: "0", intl: "00", min: 9, max: 9},
+	"PK": {code: "92", trunk: "0", intl: "00", min: 10, max: 10},
+	"BD": {code: "880", trunk: "0", intl: "00", min: 10, max: 10},
+	"LK": {code: "94", trunk: "0", intl: "00", min: 9, max: 9},
+	"NP": {code: "977", trunk: "0", intl: "00", min: 8, max: 10},
+}
+
+// The shortest and longest number E.164 allows, counted in digits including the country code.
+const (
+	minE164Digits = 8
+	maxE164Digits = 15
+)
+
+// The function reports whether `code` is a region in the table.
+func KnownRegion(code string) bool {
+	_, ok := regions[strings.ToUpper(code)]
+	return ok
+}
+
+// The function returns the E.164 form of a phone number. Numbers starting with a plus sign or the
+// international prefix of `defaultRegion` are read as international numbers; anything else is read as
+// a national number of `defaultRegion`, with or without its trunk prefix or country code. Spaces,
+// dashes, dots and parentheses are ignored.
+func Normalize(raw string, defaultRegion string) (string, error) {
+	def, ok := regions[strings.ToUpper(defaultRegion)]
+	digits, plus, err := clean(raw)
+	if err != nil {
+		return "", err
+	}
+	if plus {
+		return international(digits)
+	}
+	if !ok {
+		return "", ErrUnknownRegion
+	}
+	if def.intl != "" && strings.HasPrefix(digits, def.intl) {
+		return international(digits[len(def.intl):])
+	}
+	// National numbers never start with the trunk prefix once it is removed, so a number that starts
+	// with it is only read with the prefix stripped.
+	if def.trunk != "" && strings.HasPrefix(digits, def.trunk) {
+		if def.fits(len(digits) - len(def.trunk)) {
+			return "+" + def.code + digits[len(def.trunk):], nil
+		}
+	} else if def.fits(len(digits)) {
+		return "+" + def.code + digits, nil
+	}
+	if strings.HasPrefix(digits, def.code) && def.fits(len(digits)-len(def.code)) {
+		return "+" + digits, nil
+	}
+	return "", ErrInvalid
+}
+
+// The function returns the digits of a number and whether it started with a plus sign. Letters and
+// other symbols make the number invalid.
+func clean(raw string) (string, bool, error) {
+	raw = strings.TrimSpace(raw)
+	plus := strings.HasPrefix(raw, "+")
+	raw = strings.TrimPrefix(raw, "+")
+	var b strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", false, ErrInvalid
+		}
+	}
+	if b.Len() == 0 {
+		return "", false, ErrInvalid
+	}
+	return b.String(), plus, nil
+}
+
+// The function checks an international number given as its digits after the plus sign. Numbers of a
+// country in the table have to be of a valid length for it; others only have to fit E.164.
+func international(digits string) (string, error) {
+	if len(digits) < minE164Digits || len(digits) > maxE164Digits || digits[0] == '0' {
+		return "", ErrInvalid
+	}
+	for _, r := range regions {
+		if strings.HasPrefix(digits, r.code) && r.fits(len(digits)-len(r.code)) {
+			return "+" + digits, nil
+		}
+	}
+	for _, r := range regions {
+		if strings.HasPrefix(digits, r.code) {
+			return "", ErrInvalid
+		}
+	}
+	return "+" + digits, nil
+}
+
+// The function reports whether a national number of `n` digits is valid in the region.
+func (r region) fits(n int) bool {
+	return n >= r.min && n <= r.max
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// The counter type is a single fixed window counter kept by the MemoryStore.
+type counter struct {
+	count   int
+	resetAt time.Time
+}
+
+// MemoryStore is a Store that keeps counters in the memory of the current process. It is the default
+// store and is only correct when a single instance of the API is running.
+// @property mu - `mu` guards `counters` and `nextSweep`.
+// @property counters - `counters` maps a key to its current window.
+// @property nextSweep - `nextSweep` is the time expired counters are next removed.
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]*counter
+	nextSweep time.Time
+}
+
+// The function records a hit for `key`, starting a new window if the previous one has ended.
+func (s *MemoryStore) Hit(key string, window time.Duration) (int, time.Time, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.After(s.nextSweep) {
+		for k, c := range s.counters {
+			if !now.Before(c.resetAt) {
+				delete(s.counters, k)
+			}
+		}
+		s.nextSweep = now.Add(time.Minute)
+	}
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.resetAt) {
+		c = &counter{resetAt: now.Add(window)}
+		s.counters[key] = c
+	}
+	c.count++
+	return c.count, c.resetAt, nil
+}
+
+// The function returns a new, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: map[string]*counter{}}
+}
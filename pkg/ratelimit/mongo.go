@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The window type is the document stored in the `rate_limits` collection for every key and window.
+// @property {string} ID - The key followed by the start of the window in Unix seconds.
+// @property {int} Count - The number of hits in the window.
+// @property ExpiresAt - The time the window resets. MongoDB removes the document through a TTL index
+// once this time has passed.
+type window struct {
+	ID        string    `bson:"_id"`
+	Count     int       `bson:"count"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// MongoStore is a Store that keeps counters in MongoDB so that every instance of the API shares them.
+// Windows are aligned to multiples of their length so all instances agree on when a window starts.
+type MongoStore struct {
+	db      *mongo.Collection
+	context context.Context
+}
+
+// The function atomically increments the counter of the current window of `key`, creating it on the
+// first hit.
+func (s *MongoStore) Hit(key string, length time.Duration) (int, time.Time, error) {
+	start := time.Now().Truncate(length)
+	resetAt := start.Add(length)
+	var w window
+	err := s.db.FindOneAndUpdate(s.context,
+		bson.M{"_id": key + ":" + strconv.FormatInt(start.Unix(), 10)},
+		bson.M{"$inc": bson.M{"count": 1}, "$setOnInsert": bson.M{"expires_at": resetAt}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&w)
+	if err != nil {
+		return 0, resetAt, err
+	}
+	return w.Count, resetAt, nil
+}
+
+// The function creates the TTL index that lets MongoDB remove windows that have ended.
+func (s *MongoStore) EnsureIndexes() error {
+	_, err := s.db.Indexes().CreateOne(s.context, mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// The function returns a new MongoStore with a MongoDB database connection.
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	ctx := context.TODO()
+	return &MongoStore{db: db.Collection("rate_limits"), context: ctx}
+}
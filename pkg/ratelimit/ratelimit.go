@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store is an interface that defines where rate limit counters are kept. Counters use fixed windows:
+// every key counts hits from the start of its current window until the window resets.
+// @property Hit - Hit records a hit for `key` in a window of the given length and returns the number of
+// hits in the current window, including this one, and the time the window resets.
+type Store interface {
+	Hit(key string, window time.Duration) (int, time.Time, error)
+}
+
+// The Rule type describes a single limit: at most `Limit` hits per `Window`. A zero Rule disables the
+// limit.
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// The function reports whether the rule limits anything.
+func (r Rule) Enabled() bool {
+	return r.Limit > 0 && r.Window > 0
+}
+
+// The function parses a rule written as "<limit>/<window>", for example "5/10m" for five hits per ten
+// minutes. An empty string or "0" returns a disabled rule.
+func ParseRule(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Rule{}, nil
+	}
+	limit, window, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rule{}, fmt.Errorf("invalid rate limit %q, expected <limit>/<window>", s)
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 0 {
+		return Rule{}, fmt.Errorf("invalid rate limit %q: bad limit", s)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return Rule{}, fmt.Errorf("invalid rate limit %q: bad window", s)
+	}
+	return Rule{Limit: n, Window: d}, nil
+}
+
+// The function records a hit for `key` against the rule and reports whether the hit is allowed. When
+// it is not, the returned duration is how long the client has to wait before the window resets.
+func Allow(store Store, key string, rule Rule) (bool, time.Duration, error) {
+	count, resetAt, err := store.Hit(key, rule.Window)
+	if err != nil {
+		return false, 0, err
+	}
+	if count > rule.Limit {
+		return false, time.Until(resetAt), nil
+	}
+	return true, 0, nil
+}
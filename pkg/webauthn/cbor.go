@@ -0,0 +1,141 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// `errMalformedCBOR` is returned when authenticator data cannot be decoded.
+var errMalformedCBOR = errors.New("webauthn: malformed cbor")
+
+// `maxCBORDepth` bounds the nesting of decoded values so that hostile input cannot exhaust the stack.
+const maxCBORDepth = 16
+
+// The function decodes the first CBOR value in `data` and returns it together with the bytes that
+// follow it. It supports the subset of CBOR that authenticators produce: definite length integers,
+// byte and text strings, arrays, maps, tags and simple values. Integers are returned as int64, byte
+// strings as []byte, text strings as string, arrays as []interface{} and maps as
+// map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeValue(data, 0)
+}
+
+// The function decodes a single value at the given nesting depth.
+func decodeValue(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth || len(data) == 0 {
+		return nil, nil, errMalformedCBOR
+	}
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	if major == 7 {
+		return decodeSimple(info, data)
+	}
+	n, data, err := decodeArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, nil, errMalformedCBOR
+		}
+		return int64(n), data, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, nil, errMalformedCBOR
+		}
+		return -1 - int64(n), data, nil
+	case 2, 3:
+		if uint64(len(data)) < n {
+			return nil, nil, errMalformedCBOR
+		}
+		b := make([]byte, n)
+		copy(b, data[:n])
+		if major == 3 {
+			return string(b), data[n:], nil
+		}
+		return b, data[n:], nil
+	case 4:
+		if n > uint64(len(data)) {
+			return nil, nil, errMalformedCBOR
+		}
+		arr := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var v interface{}
+			if v, data, err = decodeValue(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, data, nil
+	case 5:
+		if n > uint64(len(data)) {
+			return nil, nil, errMalformedCBOR
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var k, v interface{}
+			if k, data, err = decodeValue(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errMalformedCBOR
+			}
+			if v, data, err = decodeValue(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, data, nil
+	default:
+		// Tags carry no meaning for the values we read, so the tagged value is returned as it is.
+		return decodeValue(data, depth+1)
+	}
+}
+
+// The function reads the argument of a data item header. Indefinite lengths are not used by
+// authenticators and are rejected.
+func decodeArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		return 0, nil, errMalformedCBOR
+	}
+}
+
+// The function decodes a simple value or float. Floats are skipped and returned as nil since nothing
+// we read from an authenticator uses them.
+func decodeSimple(info byte, data []byte) (interface{}, []byte, error) {
+	switch {
+	case info == 20:
+		return false, data, nil
+	case info == 21:
+		return true, data, nil
+	case info == 22, info == 23, info < 20:
+		return nil, data, nil
+	case info == 24 && len(data) >= 1:
+		return nil, data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return nil, data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return nil, data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return nil, data[8:], nil
+	default:
+		return nil, nil, errMalformedCBOR
+	}
+}
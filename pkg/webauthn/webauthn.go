@@ -0,0 +1,373 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// The errors returned when a ceremony fails verification. None of them should be shown to end users
+// in more detail than "the passkey could not be verified".
+var (
+	ErrInvalidClientData      = errors.New("webauthn: client data does not match the ceremony")
+	ErrInvalidAuthData        = errors.New("webauthn: invalid authenticator data")
+	ErrUserNotPresent         = errors.New("webauthn: user presence or verification missing")
+	ErrUnsupportedAttestation = errors.New("webauthn: unsupported attestation format")
+	ErrSignCount              = errors.New("webauthn: signature counter did not increase, the authenticator may be cloned")
+	ErrCredentialIDMismatch   = errors.New("webauthn: credential id does not match the authenticator data")
+)
+
+// The flags of the authenticator data.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// `encoding` is the unpadded base64url encoding WebAuthn uses for binary values in JSON.
+var encoding = base64.RawURLEncoding
+
+// The RelyingParty type describes this service to authenticators and holds what the ceremonies are
+// checked against.
+// @property {string} ID - The relying party ID, the domain credentials are scoped to.
+// @property {string} Name - The human readable name authenticators show.
+// @property {[]string} Origins - The origins the browser may report, such as "https://app.example.com".
+// @property Timeout - How long the user has to complete a ceremony.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+	Timeout time.Duration
+}
+
+// The CredentialDescriptor type identifies a credential in the options sent to the browser.
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// The CreationOptions type holds the options for `navigator.credentials.create`. Binary values are
+// base64url encoded and have to be decoded by the client before they are passed to the browser.
+type CreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int64  `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// The RequestOptions type holds the options for `navigator.credentials.get`.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// The AttestationResponse type is the JSON form of the `PublicKeyCredential` returned by
+// `navigator.credentials.create`, with binary values base64url encoded.
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// The AssertionResponse type is the JSON form of the `PublicKeyCredential` returned by
+// `navigator.credentials.get`, with binary values base64url encoded.
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// The Credential type is a registered credential as it has to be stored to verify later assertions.
+// @property {[]byte} ID - The credential ID chosen by the authenticator.
+// @property {[]byte} PublicKey - The COSE encoded public key of the credential.
+// @property {uint32} SignCount - The signature counter reported at registration.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+// The function returns a new random challenge for a ceremony.
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// The function encodes binary values the way they appear in options and responses.
+func Encode(b []byte) string {
+	return encoding.EncodeToString(b)
+}
+
+// The function decodes a base64url value from a response. Padding is tolerated since some clients
+// add it.
+func Decode(s string) ([]byte, error) {
+	return encoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// The function returns the options of a registration ceremony for the user with the given user handle
+// and names. `exclude` lists the IDs of the credentials the user already has so that an authenticator
+// is not registered twice. Credentials are required to be discoverable so they can log in without a
+// user name.
+func (rp RelyingParty) CreationOptions(challenge []byte, userHandle []byte, name string, displayName string, exclude [][]byte) CreationOptions {
+	var o CreationOptions
+	o.Challenge = Encode(challenge)
+	o.RP.ID = rp.ID
+	o.RP.Name = rp.Name
+	o.User.ID = Encode(userHandle)
+	o.User.Name = name
+	o.User.DisplayName = displayName
+	for _, alg := range []int64{AlgES256, AlgEdDSA, AlgRS256} {
+		o.PubKeyCredParams = append(o.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int64  `json:"alg"`
+		}{Type: "public-key", Alg: alg})
+	}
+	o.Timeout = rp.Timeout.Milliseconds()
+	o.ExcludeCredentials = descriptors(exclude)
+	o.AuthenticatorSelection.ResidentKey = "required"
+	o.AuthenticatorSelection.UserVerification = "required"
+	o.Attestation = "none"
+	return o
+}
+
+// The function returns the options of an authentication ceremony. `allow` may be empty, in which case
+// the browser offers every discoverable credential it has for the relying party.
+func (rp RelyingParty) RequestOptions(challenge []byte, allow [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        Encode(challenge),
+		RPID:             rp.ID,
+		Timeout:          rp.Timeout.Milliseconds(),
+		AllowCredentials: descriptors(allow),
+		UserVerification: "required",
+	}
+}
+
+// The function verifies the response of a registration ceremony that was started with `challenge`
+// and returns the new credential. Attestation is not used to make trust decisions, so "none" and
+// "packed" statements are accepted and checked for consistency only. The credential ID the client
+// reports has to be the one in the authenticator data.
+func (rp RelyingParty) VerifyRegistration(challenge []byte, resp AttestationResponse) (Credential, error) {
+	clientData, err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+	raw, err := Decode(resp.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, ErrInvalidAuthData
+	}
+	v, _, err := decodeCBOR(raw)
+	if err != nil {
+		return Credential{}, err
+	}
+	obj, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return Credential{}, ErrInvalidAuthData
+	}
+	format, _ := obj["fmt"].(string)
+	authData, _ := obj["authData"].([]byte)
+	stmt, _ := obj["attStmt"].(map[interface{}]interface{})
+
+	parsed, err := rp.parseAuthData(authData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if parsed.flags&flagAttestedData == 0 {
+		return Credential{}, ErrInvalidAuthData
+	}
+	if id, err := Decode(resp.ID); err != nil || !bytes.Equal(id, parsed.credentialID) {
+		return Credential{}, ErrCredentialIDMismatch
+	}
+	key, err := parsePublicKey(parsed.publicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	if err := verifyAttestation(format, stmt, authData, clientDataHash[:], key); err != nil {
+		return Credential{}, err
+	}
+	return Credential{ID: parsed.credentialID, PublicKey: parsed.publicKey, SignCount: parsed.signCount}, nil
+}
+
+// The function verifies the response of an authentication ceremony that was started with `challenge`
+// against the stored public key and signature counter of the credential. It returns the new counter,
+// which has to be stored for the next assertion.
+func (rp RelyingParty) VerifyAssertion(challenge []byte, cosePublicKey []byte, signCount uint32, resp AssertionResponse) (uint32, error) {
+	clientData, err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+	authData, err := Decode(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, ErrInvalidAuthData
+	}
+	sig, err := Decode(resp.Response.Signature)
+	if err != nil {
+		return 0, errInvalidSignature
+	}
+	parsed, err := rp.parseAuthData(authData)
+	if err != nil {
+		return 0, err
+	}
+	key, err := parsePublicKey(cosePublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	if err := key.verify(append(authData[:len(authData):len(authData)], clientDataHash[:]...), sig); err != nil {
+		return 0, err
+	}
+	if (parsed.signCount != 0 || signCount != 0) && parsed.signCount <= signCount {
+		return 0, ErrSignCount
+	}
+	return parsed.signCount, nil
+}
+
+// The function decodes the client data JSON and checks its type, challenge and origin. It returns the
+// raw client data, whose hash is part of the signed data.
+func (rp RelyingParty) verifyClientData(encoded string, typ string, challenge []byte) ([]byte, error) {
+	raw, err := Decode(encoded)
+	if err != nil {
+		return nil, ErrInvalidClientData
+	}
+	var data struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, ErrInvalidClientData
+	}
+	got, err := Decode(data.Challenge)
+	if err != nil || data.Type != typ || !bytes.Equal(got, challenge) {
+		return nil, ErrInvalidClientData
+	}
+	for _, origin := range rp.Origins {
+		if data.Origin == origin {
+			return raw, nil
+		}
+	}
+	return nil, ErrInvalidClientData
+}
+
+// The authData type holds the parts of the authenticator data the ceremonies check.
+type authData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// The function parses authenticator data and checks the relying party ID hash and that the user was
+// present and verified. The attested credential data is only parsed when its flag is set.
+func (rp RelyingParty) parseAuthData(data []byte) (authData, error) {
+	if len(data) < 37 {
+		return authData{}, ErrInvalidAuthData
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data[:32], rpIDHash[:]) {
+		return authData{}, ErrInvalidAuthData
+	}
+	out := authData{flags: data[32], signCount: binary.BigEndian.Uint32(data[33:37])}
+	if out.flags&flagUserPresent == 0 || out.flags&flagUserVerified == 0 {
+		return authData{}, ErrUserNotPresent
+	}
+	if out.flags&flagAttestedData == 0 {
+		return out, nil
+	}
+	rest := data[37:]
+	if len(rest) < 18 {
+		return authData{}, ErrInvalidAuthData
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+		return authData{}, ErrInvalidAuthData
+	}
+	out.credentialID = rest[:idLen]
+	rest = rest[idLen:]
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return authData{}, ErrInvalidAuthData
+	}
+	out.publicKey = rest[:len(rest)-len(after)]
+	return out, nil
+}
+
+// The function checks an attestation statement. "none" must be empty. "packed" is either self
+// attestation, signed by the credential itself, or signed by the certificate in `x5c`, whose chain is
+// not checked since attestation is not used for trust decisions.
+func verifyAttestation(format string, stmt map[interface{}]interface{}, authData []byte, clientDataHash []byte, credentialKey publicKey) error {
+	switch format {
+	case "none":
+		if len(stmt) != 0 {
+			return ErrUnsupportedAttestation
+		}
+		return nil
+	case "packed":
+		alg, _ := stmt["alg"].(int64)
+		sig, _ := stmt["sig"].([]byte)
+		signed := append(authData[:len(authData):len(authData)], clientDataHash...)
+		chain, _ := stmt["x5c"].([]interface{})
+		if len(chain) == 0 {
+			if alg != credentialKey.alg {
+				return ErrUnsupportedAttestation
+			}
+			return credentialKey.verify(signed, sig)
+		}
+		der, _ := chain[0].([]byte)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return ErrUnsupportedAttestation
+		}
+		return publicKey{alg: alg, key: cert.PublicKey}.verify(signed, sig)
+	default:
+		return ErrUnsupportedAttestation
+	}
+}
+
+// The function returns the descriptors of the given credential IDs.
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	out := make([]CredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, CredentialDescriptor{Type: "public-key", ID: Encode(id)})
+	}
+	return out
+}
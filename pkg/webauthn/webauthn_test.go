@@ -0,0 +1,322 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+const testOrigin = "https://app.example.com"
+
+var testRP = RelyingParty{ID: "example.com", Name: "Sharir", Origins: []string{testOrigin}, Timeout: time.Minute}
+
+// encodeCBOR encodes the subset of CBOR the authenticator below produces. Map keys are written in a
+// fixed order so the output is deterministic.
+func encodeCBOR(v interface{}) []byte {
+	header := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			b := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(b[1:], uint16(n))
+			return b
+		default:
+			b := []byte{major<<5 | 26, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(b[1:], uint32(n))
+			return b
+		}
+	}
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case []interface{}:
+		out := header(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	case map[interface{}]interface{}:
+		keys := make([][]byte, 0, len(v))
+		values := map[string][]byte{}
+		for k, item := range v {
+			key := encodeCBOR(k)
+			keys = append(keys, key)
+			values[string(key)] = encodeCBOR(item)
+		}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+		out := header(5, uint64(len(v)))
+		for _, key := range keys {
+			out = append(append(out, key...), values[string(key)]...)
+		}
+		return out
+	}
+	panic("cannot encode value")
+}
+
+// softAuthenticator is an ES256 authenticator in memory. Its fields can be changed between ceremonies
+// to produce responses a real authenticator or browser would not.
+type softAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	rpID      string
+	origin    string
+	flags     byte
+	signCount uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, id: id, rpID: testRP.ID, origin: testOrigin, flags: flagUserPresent | flagUserVerified}
+}
+
+func (a *softAuthenticator) cosePublicKey() []byte {
+	x, y := make([]byte, 32), make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	return encodeCBOR(map[interface{}]interface{}{
+		coseKty: ktyEC2, coseAlg: AlgES256, coseCrv: crvP256, coseX: x, coseY: y,
+	})
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	out := append([]byte{}, rpIDHash[:]...)
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedData
+	}
+	out = append(out, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(out[33:], a.signCount)
+	if attested {
+		out = append(out, make([]byte, 16)...)
+		out = append(out, byte(len(a.id)>>8), byte(len(a.id)))
+		out = append(out, a.id...)
+		out = append(out, a.cosePublicKey()...)
+	}
+	return out
+}
+
+func (a *softAuthenticator) clientData(typ string, challenge []byte) []byte {
+	raw, _ := json.Marshal(map[string]string{"type": typ, "challenge": Encode(challenge), "origin": a.origin})
+	return raw
+}
+
+func (a *softAuthenticator) sign(authData []byte, clientData []byte) []byte {
+	hash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), hash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return sig
+}
+
+// register answers a registration ceremony with a packed self attestation.
+func (a *softAuthenticator) register(challenge []byte) AttestationResponse {
+	clientData := a.clientData("webauthn.create", challenge)
+	authData := a.authData(true)
+	obj := encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "packed",
+		"authData": authData,
+		"attStmt":  map[interface{}]interface{}{"alg": AlgES256, "sig": a.sign(authData, clientData)},
+	})
+	var resp AttestationResponse
+	resp.ID, resp.Type = Encode(a.id), "public-key"
+	resp.Response.ClientDataJSON = Encode(clientData)
+	resp.Response.AttestationObject = Encode(obj)
+	return resp
+}
+
+// assert answers an authentication ceremony and increments the signature counter.
+func (a *softAuthenticator) assert(challenge []byte) AssertionResponse {
+	a.signCount++
+	clientData := a.clientData("webauthn.get", challenge)
+	authData := a.authData(false)
+	var resp AssertionResponse
+	resp.ID, resp.Type = Encode(a.id), "public-key"
+	resp.Response.ClientDataJSON = Encode(clientData)
+	resp.Response.AuthenticatorData = Encode(authData)
+	resp.Response.Signature = Encode(a.sign(authData, clientData))
+	return resp
+}
+
+func challenge(t *testing.T) []byte {
+	t.Helper()
+	c, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	a := newSoftAuthenticator(t)
+	c := challenge(t)
+	cred, err := testRP.VerifyRegistration(c, a.register(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cred.ID, a.id) || !bytes.Equal(cred.PublicKey, a.cosePublicKey()) {
+		t.Fatal("registered credential does not match the authenticator")
+	}
+	count := cred.SignCount
+	for i := 0; i < 2; i++ {
+		c = challenge(t)
+		if count, err = testRP.VerifyAssertion(c, cred.PublicKey, count, a.assert(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count != a.signCount {
+		t.Errorf("sign count %d, want %d", count, a.signCount)
+	}
+}
+
+func TestRegistrationRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(a *softAuthenticator, c []byte) AttestationResponse
+		want error
+	}{
+		{"bad origin", func(a *softAuthenticator, c []byte) AttestationResponse {
+			a.origin = "https://evil.example.com"
+			return a.register(c)
+		}, ErrInvalidClientData},
+		{"bad challenge", func(a *softAuthenticator, c []byte) AttestationResponse {
+			return a.register([]byte("another challenge"))
+		}, ErrInvalidClientData},
+		{"assertion client data", func(a *softAuthenticator, c []byte) AttestationResponse {
+			resp := a.register(c)
+			resp.Response.ClientDataJSON = Encode(a.clientData("webauthn.get", c))
+			return resp
+		}, ErrInvalidClientData},
+		{"bad rp id hash", func(a *softAuthenticator, c []byte) AttestationResponse {
+			a.rpID = "evil.example.com"
+			return a.register(c)
+		}, ErrInvalidAuthData},
+		{"user not verified", func(a *softAuthenticator, c []byte) AttestationResponse {
+			a.flags = flagUserPresent
+			return a.register(c)
+		}, ErrUserNotPresent},
+		{"credential id mismatch", func(a *softAuthenticator, c []byte) AttestationResponse {
+			resp := a.register(c)
+			resp.ID = Encode([]byte("some other credential"))
+			return resp
+		}, ErrCredentialIDMismatch},
+		{"bad attestation signature", func(a *softAuthenticator, c []byte) AttestationResponse {
+			resp := a.register(c)
+			resp.Response.ClientDataJSON = Encode(append(a.clientData("webauthn.create", c), ' '))
+			return resp
+		}, errInvalidSignature},
+		{"malformed cbor map", func(a *softAuthenticator, c []byte) AttestationResponse {
+			resp := a.register(c)
+			obj, _ := Decode(resp.Response.AttestationObject)
+			resp.Response.AttestationObject = Encode(obj[:len(obj)/2])
+			return resp
+		}, errMalformedCBOR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := challenge(t)
+			if _, err := testRP.VerifyRegistration(c, tt.run(newSoftAuthenticator(t), c)); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssertionRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(a *softAuthenticator, c []byte) AssertionResponse
+		want error
+	}{
+		{"bad origin", func(a *softAuthenticator, c []byte) AssertionResponse {
+			a.origin = "https://evil.example.com"
+			return a.assert(c)
+		}, ErrInvalidClientData},
+		{"bad challenge", func(a *softAuthenticator, c []byte) AssertionResponse {
+			return a.assert([]byte("another challenge"))
+		}, ErrInvalidClientData},
+		{"bad rp id hash", func(a *softAuthenticator, c []byte) AssertionResponse {
+			a.rpID = "evil.example.com"
+			return a.assert(c)
+		}, ErrInvalidAuthData},
+		{"sign count regression", func(a *softAuthenticator, c []byte) AssertionResponse {
+			a.signCount = 0
+			return a.assert(c)
+		}, ErrSignCount},
+		{"sign count repeated", func(a *softAuthenticator, c []byte) AssertionResponse {
+			a.signCount--
+			return a.assert(c)
+		}, ErrSignCount},
+		{"bad signature", func(a *softAuthenticator, c []byte) AssertionResponse {
+			resp := a.assert(c)
+			other := newSoftAuthenticator(t)
+			other.signCount = a.signCount - 1
+			resp.Response.Signature = other.assert(c).Response.Signature
+			return resp
+		}, errInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newSoftAuthenticator(t)
+			c := challenge(t)
+			cred, err := testRP.VerifyRegistration(c, a.register(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.signCount = 5
+			c = challenge(t)
+			count, err := testRP.VerifyAssertion(c, cred.PublicKey, 0, a.assert(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c = challenge(t)
+			if _, err := testRP.VerifyAssertion(c, cred.PublicKey, count, tt.run(a, c)); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMalformedCBOR(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x81}, maxCBORDepth+2)
+	tests := map[string][]byte{
+		"empty":                {},
+		"truncated map":        {0xa2, 0x01, 0x02, 0x03},
+		"map length too large": {0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"byte string too long": {0x45, 0x01, 0x02},
+		"array map key":        {0xa1, 0x80, 0x01},
+		"indefinite length":    {0xbf, 0x01, 0x02, 0xff},
+		"too deep":             append(deep, 0x01),
+	}
+	for name, data := range tests {
+		if _, _, err := decodeCBOR(data); err != errMalformedCBOR {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}